@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/hydridity/Schematic/pkg/schema"
 	"log"
@@ -118,6 +119,15 @@ func debugProcessConfig(config Config) {
 }
 
 func main() {
+	explain := flag.Bool("explain", false, "print every matching step of the validation")
+	modifiers := flag.Bool("modifiers", false, "print the modifiers a schema can call and exit")
+	flag.Parse()
+
+	if *modifiers {
+		fmt.Printf("Modifiers:\n%s", schema.DefaultModifierRegistry().Help())
+		return
+	}
+
 	config := loadConfig()
 
 	variableStore := BuildVariableStore(config)
@@ -128,15 +138,22 @@ func main() {
 	}
 	schemaCompiled, err := schema.CreateSchema(config.Schema)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Invalid schema:\n%s", schema.Diagnose(err))
 	}
 
-	inputStr := "deployment/group1/helm-project1/postgres/admin" // TODO: Some inputs for raw API Vault paths will have "data" after mounth path
-	//TODO Example: "deployment/data/group1/helm-project1/postgres/admin"
+	// Raw API paths of KV v2 mounts have "data" after the mount path, e.g.
+	// "deployment/data/group1/helm-project1/postgres/admin", which a schema accepts with deployment/(data)?/...
+	inputStr := "deployment/group1/helm-project1/postgres/admin"
 	fmt.Println("Input to validate:", inputStr)
+	if *explain {
+		context.Trace = &schema.Trace{}
+	}
 	err = schemaCompiled.Validate(inputStr, &context)
+	if context.Trace != nil {
+		fmt.Print(context.Trace.String())
+	}
 	if err != nil {
-		fmt.Printf("Validation failed: %s\n", err)
+		fmt.Printf("Validation failed:\n%s", schema.Diagnose(err))
 		os.Exit(1)
 	} else {
 		fmt.Println("Validation succeeded")
@@ -2,56 +2,255 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 )
 
-// Define a simple AST for a schema like: $gitlab_path.strip_prefix("helm-")/$[technologies]/+
+// Define a simple AST for a schema like: $gitlab_path.strip_prefix("helm-").lower()/$[technologies]/+
 type SchemaAST struct {
-	Parts []*Part `@@ ("/" @@)*`
+	// Tokens the schema was parsed from, see Source.
+	Tokens []lexer.Token
+
+	Parts []*Part `@@+`
 }
 
+// Part is a single segment of the schema along with the separator in front of it, which is empty for the first
+// part. A segment which isn't a wildcard is a sequence of pieces, e.g. app-${env}-db, matched together against one
+// input segment.
 type Part struct {
-	Var      *Var      `  @@`
-	VarSet   *VarSet   `| @@`
-	Wildcard *Wildcard `| @@`
-	Literal  *string   `| @Ident`
-	Regex    *string   `| @RegexString`
+	Pos lexer.Position
+
+	Separator string     `@("/" | ":")?`
+	Wildcard  *Wildcard  `( @@`
+	Condition *Condition `| @@`
+	Group     *Group     `| @@`
+	Negation  *Negation  `| @@`
+	Pieces    []*Piece   `| @@+ )`
+}
+
+// Group matches any one of its branches, each being a schema of its own, e.g. (apps|infra/+). A group followed by
+// '?' is optional and matched when possible, with '??' it's left out when possible.
+type Group struct {
+	Pos lexer.Position
+
+	Branches []*SchemaAST `"(" @@ ( "|" @@ )* ")"`
+	Optional string       `@( "?" "?"? )?`
+	Capture  *Capture     `@@?`
+}
+
+type Piece struct {
+	Pos lexer.Position
+
+	Var     *Var     `( @@`
+	VarSet  *VarSet  `| @@`
+	Backref *Backref `| @@`
+	Literal *string  `| @(Ident | Int | Text | ".")+`
+	Regex   *string  `| @RegexString`
+	Type    *string  `| @TypedSegment )`
+	// Flags change how the piece is matched, e.g. secret~i ignores case.
+	Flags   string   `( "~" @Ident )?`
+	Capture *Capture `@@?`
+}
+
+// Backref matches the same input as an earlier capture, e.g. \team or \{project}-db. Regexes refer to captures
+// with \k<name> instead.
+type Backref struct {
+	Name string `"\\" ( "{" @Ident "}" | @Ident )`
 }
 
 type Wildcard struct {
+	Pos lexer.Position
+
 	Symbol     string      `@("+" | "*")`
 	Quantifier *Quantifier `@@?`
+	// Limits restrict every segment consumed by the wildcard, e.g. +<len 3..40, charset [a-z0-9-]>.
+	Limits *string `@TypedSegment?`
+	// Exclusion is what the segments consumed by the wildcard must not match, e.g. +!$[reserved]. A capture
+	// following it names the whole wildcard.
+	Exclusion *Negation `@@?`
+	Capture   *Capture  `@@?`
+}
+
+// Condition matches one of two schemas depending on the value of an earlier capture, compared with a literal or a
+// regex, e.g. (?env=prod:$[prod_technologies]|$[technologies]). Without the second schema it matches nothing when
+// the value differs.
+type Condition struct {
+	Pos lexer.Position
+
+	Capture string     `"(" "?" @Ident "="`
+	Literal *string    `( @(Ident | Int | Text | ".")+`
+	Regex   *string    `| @RegexString ) ":"`
+	Then    *SchemaAST `@@`
+	Else    *SchemaAST `( "|" @@ )? ")"`
 }
 
+// Negation matches a single segment which the negated piece or group does not match, e.g. !tmp, !#^tmp-# or
+// !$[reserved].
+type Negation struct {
+	Pos lexer.Position
+
+	Group   *Group   `"!" ( @@`
+	Var     *Var     `| @@`
+	VarSet  *VarSet  `| @@`
+	Backref *Backref `| @@`
+	Literal *string  `| @(Ident | Int | Text | ".")+`
+	Regex   *string  `| @RegexString`
+	Type    *string  `| @TypedSegment )`
+	Flags   string   `( "~" @Ident )?`
+	Capture *Capture `@@?`
+}
+
+// Capture names the input matched by a wildcard or a piece, e.g. +:{role} or $[technologies]:{tech}_admin. The
+// name may be written without braces, e.g. +:role, which the schema package reads as a ':' separator followed by a
+// literal once ':' separates segments of the schema.
+type Capture struct {
+	Pos lexer.Position
+
+	Braced bool   `":" ( @"{"`
+	Name   string `@Ident "}" | @Ident )`
+}
+
+// Quantifier gives the number of segments a wildcard consumes: {n} is exactly n, {min,max} a range, and either
+// bound of the range may be left out, e.g. {2,} or {,3}.
 type Quantifier struct {
-	Min int `"{" @Int`
-	Max int `( "," @Int )? "}"`
+	Pos lexer.Position
+
+	Min   *int `"{" @Int?`
+	Range bool `@","?`
+	Max   *int `@Int? "}"`
+}
+
+// Bounds returns the minimum and maximum number of segments, the maximum being negative when there is none.
+func (q *Quantifier) Bounds() (int, int) {
+	minimum, maximum := 0, -1
+	if q.Min != nil {
+		minimum = *q.Min
+	}
+	switch {
+	case q.Max != nil:
+		maximum = *q.Max
+	case !q.Range:
+		maximum = minimum
+	}
+	return minimum, maximum
+}
+
+// Bounds returns the minimum and maximum number of segments the wildcard consumes, the maximum being negative when
+// there is none. A '+' whose quantifier leaves out the minimum consumes at least one segment, e.g. +{,3}.
+func (w *Wildcard) Bounds() (int, int) {
+	switch {
+	case w.Quantifier == nil && w.Symbol == "+":
+		return 1, 1
+	case w.Quantifier == nil:
+		return 0, -1
+	}
+	minimum, maximum := w.Quantifier.Bounds()
+	if w.Symbol == "+" && w.Quantifier.Min == nil {
+		minimum = 1
+	}
+	return minimum, maximum
+}
+
+func (q *Quantifier) String() string {
+	minimum, maximum := q.Bounds()
+	switch {
+	case maximum < 0:
+		return fmt.Sprintf("{%d,}", minimum)
+	case minimum == maximum:
+		return fmt.Sprintf("{%d}", minimum)
+	default:
+		return fmt.Sprintf("{%d,%d}", minimum, maximum)
+	}
 }
 
+// Var references a context variable, either as $name or as ${name} when it's followed by more text in the segment.
 type Var struct {
-	Name     string    `"$" @Ident`
-	Modifier *Modifier `( "." @@ )?`
+	Braced    bool        `"$" ( @"{"`
+	Name      string      `@Ident "}" | @Ident )`
+	Modifiers []*Modifier `( "." @@ )*`
 }
 
+// VarSet references a context variable set, e.g. $[technologies], optionally followed by modifiers applied to its
+// members, e.g. $[technologies].lower().without("legacy").
 type VarSet struct {
-	Name string `"$""[" @Ident "]"`
+	Name      string      `"$""[" @Ident "]"`
+	Modifiers []*Modifier `( "." @@ )*`
 }
 
 type Modifier struct {
-	Func string   `@Ident "("`
-	Args []string `( Whitespace? @String ( Whitespace? "," Whitespace? @String )* Whitespace? ) ")"`
+	Pos lexer.Position
+
+	Func string      `@Ident "("`
+	Args []*Argument `( Whitespace? @@ ( Whitespace? "," Whitespace? @@ )* )? Whitespace? ")"`
+}
+
+// Argument is an argument of a modifier call: a quoted string, an integer, true or false, or a list of quoted
+// strings, e.g. ["legacy", "tmp"]. List is set for a list, which may be empty.
+type Argument struct {
+	Pos lexer.Position
+
+	Text  *string  `( @String`
+	Int   *Integer `| @(Int | Text)`
+	Bool  *Boolean `| @("true" | "false")`
+	List  bool     `| @"[" Whitespace?`
+	Items []string `( @String ( Whitespace? "," Whitespace? @String )* )? Whitespace? "]" )`
+}
+
+// Integer is an integer argument. Negative integers are lexed as text, so it's converted here.
+type Integer int
+
+func (i *Integer) Capture(values []string) error {
+	value, err := strconv.Atoi(values[0])
+	if err != nil {
+		return fmt.Errorf("expected an integer, got '%s'", values[0])
+	}
+	*i = Integer(value)
+	return nil
+}
+
+// Boolean is a true or false argument.
+type Boolean bool
+
+func (b *Boolean) Capture(values []string) error {
+	*b = values[0] == "true"
+	return nil
+}
+
+// String renders the argument as it's written in the schema.
+func (a *Argument) String() string {
+	switch {
+	case a.Text != nil:
+		return strconv.Quote(*a.Text)
+	case a.Int != nil:
+		return strconv.Itoa(int(*a.Int))
+	case a.Bool != nil:
+		return strconv.FormatBool(bool(*a.Bool))
+	}
+	items := make([]string, 0, len(a.Items))
+	for _, item := range a.Items {
+		items = append(items, strconv.Quote(item))
+	}
+	return "[" + strings.Join(items, ", ") + "]"
 }
 
 var schemaLexer = lexer.MustSimple([]lexer.SimpleRule{
 	{Name: "Ident", Pattern: `[a-zA-Z_][a-zA-Z0-9_-]*`},
 	{Name: "String", Pattern: `"(?:\\.|[^"])*"`},
 	{Name: "RegexString", Pattern: "#[^#]*#"},
+	{Name: "TypedSegment", Pattern: "<[^<>]*>"},
 	{Name: "Slash", Pattern: `/`},
 	{Name: "Dot", Pattern: `\.`},
 	{Name: "Comma", Pattern: `\,`},
+	{Name: "Colon", Pattern: `:`},
+	{Name: "Pipe", Pattern: `\|`},
+	{Name: "Bang", Pattern: `!`},
+	{Name: "Equals", Pattern: `=`},
+	{Name: "Tilde", Pattern: `~`},
+	{Name: "Backslash", Pattern: `\\`},
+	{Name: "Question", Pattern: `\?`},
 	{Name: "Plus", Pattern: `\+`},
 	{Name: "Star", Pattern: `\*`},
 	{Name: "Hashtag", Pattern: `\#`},
@@ -63,58 +262,191 @@ var schemaLexer = lexer.MustSimple([]lexer.SimpleRule{
 	{Name: "LCBracket", Pattern: `\{`},
 	{Name: "RCBracket", Pattern: `\}`},
 	{Name: "Int", Pattern: `[0-9]+`},
+	{Name: "Text", Pattern: `[a-zA-Z0-9_-]+`},
 	{Name: "Whitespace", Pattern: `[ \t\n\r]+`},
 })
 
+var schemaSymbols = schemaLexer.Symbols()
+
 func unquoteRegexString(token lexer.Token) (lexer.Token, error) {
 	token.Value = strings.Trim(token.Value, "#")
 	return token, nil
 }
 
+// unquoteTypedSegment strips the brackets of a typed segment, leaving its type and arguments, e.g. int:1..99.
+func unquoteTypedSegment(token lexer.Token) (lexer.Token, error) {
+	token.Value = strings.TrimSuffix(strings.TrimPrefix(token.Value, "<"), ">")
+	return token, nil
+}
+
 func NewParser() (*participle.Parser[SchemaAST], error) {
 	return participle.Build[SchemaAST](
 		participle.Lexer(schemaLexer),
+		// A ':' is only a separator when it doesn't start a capture, e.g. +:$[tags], and a '.' is only literal
+		// text when it doesn't start a modifier, e.g. ${name}.io, which takes a few tokens to tell
+		participle.UseLookahead(4),
 		participle.Unquote("String"),
 		participle.Map(unquoteRegexString, "RegexString"),
+		participle.Map(unquoteTypedSegment, "TypedSegment"),
 	)
 }
 
+// Source renders the schema from the tokens it was parsed from, e.g. to show the branches of a group.
+func (s *SchemaAST) Source() string {
+	builder := strings.Builder{}
+	for _, token := range s.Tokens {
+		switch token.Type {
+		case schemaSymbols["String"]:
+			builder.WriteString(strconv.Quote(token.Value))
+		case schemaSymbols["RegexString"]:
+			builder.WriteString("#" + token.Value + "#")
+		case schemaSymbols["TypedSegment"]:
+			builder.WriteString("<" + token.Value + ">")
+		default:
+			builder.WriteString(token.Value)
+		}
+	}
+	return builder.String()
+}
+
 func (p *Part) String() string {
 	builder := strings.Builder{}
 
+	switch {
+	case p.Wildcard != nil:
+		builder.WriteString("Wildcard:")
+		builder.WriteString(p.Wildcard.Symbol)
+		if p.Wildcard.Quantifier != nil {
+			builder.WriteString(fmt.Sprintf("\n    Quantifier: %s", p.Wildcard.Quantifier.String()))
+		}
+		if p.Wildcard.Limits != nil {
+			builder.WriteString(fmt.Sprintf("\n    Limits: %s", *p.Wildcard.Limits))
+		}
+		if p.Wildcard.Exclusion != nil {
+			builder.WriteString("\n  Exclusion: ")
+			builder.WriteString(p.Wildcard.Exclusion.String())
+		}
+		if p.Wildcard.Capture != nil {
+			builder.WriteString(fmt.Sprintf("\n    Capture: %s", p.Wildcard.Capture.Name))
+		}
+	case p.Negation != nil:
+		builder.WriteString(p.Negation.String())
+	case p.Condition != nil:
+		builder.WriteString(fmt.Sprintf("Condition: %s=", p.Condition.Capture))
+		if p.Condition.Regex != nil {
+			builder.WriteString("#" + *p.Condition.Regex + "#")
+		} else {
+			builder.WriteString(*p.Condition.Literal)
+		}
+		builder.WriteString("\n  Then: ")
+		builder.WriteString(p.Condition.Then.Source())
+		if p.Condition.Else != nil {
+			builder.WriteString("\n  Else: ")
+			builder.WriteString(p.Condition.Else.Source())
+		}
+	case p.Group != nil:
+		builder.WriteString("Group:")
+		builder.WriteString(p.Group.Optional)
+		for _, branch := range p.Group.Branches {
+			builder.WriteString("\n  Branch: ")
+			builder.WriteString(branch.Source())
+		}
+		if p.Group.Capture != nil {
+			builder.WriteString(fmt.Sprintf("\n    Capture: %s", p.Group.Capture.Name))
+		}
+	case len(p.Pieces) == 1:
+		builder.WriteString(p.Pieces[0].String())
+	default:
+		builder.WriteString("Composite:")
+		for _, piece := range p.Pieces {
+			builder.WriteString("\n  ")
+			builder.WriteString(piece.String())
+		}
+	}
+
+	return builder.String()
+}
+
+func (p *Piece) String() string {
+	builder := strings.Builder{}
+
 	switch {
 	case p.Var != nil:
 		builder.WriteString("Variable: ")
 		builder.WriteString(p.Var.Name)
-		if p.Var.Modifier != nil {
-			args := strings.Join(p.Var.Modifier.Args, ", ")
-			builder.WriteString(fmt.Sprintf("\n    Modifier: %s(%s), Arguments: %s", p.Var.Modifier.Func, args, args))
-		}
+		writeModifiers(&builder, p.Var.Modifiers)
 	case p.VarSet != nil:
 		builder.WriteString("VarSet:")
 		builder.WriteString(p.VarSet.Name)
-	case p.Wildcard != nil:
-		builder.WriteString("Wildcard:")
-		builder.WriteString(p.Wildcard.Symbol)
-		if p.Wildcard.Quantifier != nil {
-			if p.Wildcard.Quantifier.Min != 0 {
-				builder.WriteString(fmt.Sprintf("\n Quantifier Min: %d\n", p.Wildcard.Quantifier.Min))
-			} else {
-				builder.WriteString("Quantifier Min is Missing!")
-			}
-
-			if p.Wildcard.Quantifier.Max != 0 {
-				builder.WriteString(fmt.Sprintf("\n Quantifier Max: %d\n", p.Wildcard.Quantifier.Max))
-			} else {
-				builder.WriteString(" Quantifier Max is Missing!")
-			}
-		}
+		writeModifiers(&builder, p.VarSet.Modifiers)
+	case p.Backref != nil:
+		builder.WriteString("Backref:")
+		builder.WriteString(p.Backref.Name)
 	case p.Literal != nil:
 		builder.WriteString("Literal:")
 		builder.WriteString(*p.Literal)
 	case p.Regex != nil:
 		builder.WriteString("Regex:")
 		builder.WriteString(*p.Regex)
+	case p.Type != nil:
+		builder.WriteString("Type:")
+		builder.WriteString(*p.Type)
+	}
+	if p.Flags != "" {
+		builder.WriteString(fmt.Sprintf("\n    Flags: %s", p.Flags))
+	}
+	if p.Capture != nil {
+		builder.WriteString(fmt.Sprintf("\n    Capture: %s", p.Capture.Name))
+	}
+
+	return builder.String()
+}
+
+func writeModifiers(builder *strings.Builder, modifiers []*Modifier) {
+	for _, modifier := range modifiers {
+		args := make([]string, 0, len(modifier.Args))
+		for _, arg := range modifier.Args {
+			args = append(args, arg.String())
+		}
+		builder.WriteString(fmt.Sprintf("\n    Modifier: %s(%s)", modifier.Func, strings.Join(args, ", ")))
+	}
+}
+
+func (n *Negation) String() string {
+	builder := strings.Builder{}
+
+	builder.WriteString("Negation:")
+	switch {
+	case n.Group != nil:
+		builder.WriteString("Group:")
+		for _, branch := range n.Group.Branches {
+			builder.WriteString("\n  Branch: ")
+			builder.WriteString(branch.Source())
+		}
+	case n.Var != nil:
+		builder.WriteString("Variable:")
+		builder.WriteString(n.Var.Name)
+	case n.VarSet != nil:
+		builder.WriteString("VarSet:")
+		builder.WriteString(n.VarSet.Name)
+	case n.Backref != nil:
+		builder.WriteString("Backref:")
+		builder.WriteString(n.Backref.Name)
+	case n.Literal != nil:
+		builder.WriteString("Literal:")
+		builder.WriteString(*n.Literal)
+	case n.Regex != nil:
+		builder.WriteString("Regex:")
+		builder.WriteString(*n.Regex)
+	case n.Type != nil:
+		builder.WriteString("Type:")
+		builder.WriteString(*n.Type)
+	}
+	if n.Flags != "" {
+		builder.WriteString(fmt.Sprintf("\n    Flags: %s", n.Flags))
+	}
+	if n.Capture != nil {
+		builder.WriteString(fmt.Sprintf("\n    Capture: %s", n.Capture.Name))
 	}
 
 	return builder.String()
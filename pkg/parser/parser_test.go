@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"github.com/alecthomas/participle/v2/lexer"
+	"slices"
 	"testing"
 )
 
@@ -238,6 +239,89 @@ var singleTokenTests = []tokenTest{
 			` ,`,
 		},
 	},
+	{
+		name:           "Colon",
+		tokensSequence: []string{"Colon"},
+		success: []string{
+			`:`,
+		},
+		fail: []string{
+			`::`,
+			`\`,
+			` :`,
+		},
+	},
+	{
+		name:           "Pipe",
+		tokensSequence: []string{"Pipe"},
+		success: []string{
+			`|`,
+		},
+		fail: []string{
+			`||`,
+			`\`,
+			` |`,
+		},
+	},
+	{
+		name:           "Question",
+		tokensSequence: []string{"Question"},
+		success: []string{
+			`?`,
+		},
+		fail: []string{
+			`??`,
+			`\`,
+			` ?`,
+		},
+	},
+	{
+		name:           "Bang",
+		tokensSequence: []string{"Bang"},
+		success: []string{
+			`!`,
+		},
+		fail: []string{
+			`!!`,
+			`\`,
+			` !`,
+		},
+	},
+	{
+		name:           "Backslash",
+		tokensSequence: []string{"Backslash"},
+		success: []string{
+			`\`,
+		},
+		fail: []string{
+			`\\`,
+			` \`,
+		},
+	},
+	{
+		name:           "Equals",
+		tokensSequence: []string{"Equals"},
+		success: []string{
+			`=`,
+		},
+		fail: []string{
+			`==`,
+			`\`,
+			` =`,
+		},
+	},
+	{
+		name:           "Tilde",
+		tokensSequence: []string{"Tilde"},
+		success: []string{
+			`~`,
+		},
+		fail: []string{
+			`~~`,
+			`-`,
+			` ~`,
+		},
+	},
 	{
 		name:           "Plus",
 		tokensSequence: []string{"Plus"},
@@ -372,6 +456,22 @@ var singleTokenTests = []tokenTest{
 			`(`,
 		},
 	},
+	{
+		name:           "TypedSegment",
+		tokensSequence: []string{"TypedSegment"},
+		success: []string{
+			`<uuid>`,
+			`<int:1..99>`,
+			`<date:2006-01-02>`,
+			`<>`,
+		},
+		fail: []string{
+			`<uuid`,
+			`uuid>`,
+			`<a<b>`,
+			`<a>b>`,
+		},
+	},
 	{
 		name:           "Int",
 		tokensSequence: []string{"Int"},
@@ -393,6 +493,21 @@ var singleTokenTests = []tokenTest{
 			`41.11`,
 		},
 	},
+	{
+		name:           "Text",
+		tokensSequence: []string{"Text"},
+		success: []string{
+			"-",
+			"-db",
+			"-42",
+			"-a-b_c1",
+		},
+		fail: []string{
+			"a",
+			"42",
+			"-$",
+		},
+	},
 
 	{
 		name:           "Whitespace",
@@ -428,6 +543,19 @@ var pairExcludes = []pairExclude{
 		first:  "Int",
 		second: "Ident",
 	},
+	// Text continues with any Ident, Int or Text characters, and an Ident continues with Text characters
+	{
+		first:  "Text",
+		second: "Ident",
+	},
+	{
+		first:  "Text",
+		second: "Int",
+	},
+	{
+		first:  "Ident",
+		second: "Text",
+	},
 	// We use a lot of whitespace-prefixed valid tokens to form an invalid token, which leads to correctly parsing the resulting
 	// pair as a [Whitespace, Token].
 	{
@@ -448,3 +576,346 @@ func TestLexer(t *testing.T) {
 		}
 	})
 }
+
+func parseString(s string, t *testing.T) *SchemaAST {
+	parser, err := NewParser()
+	if err != nil {
+		t.Fatalf("Cannot create parser: %v", err)
+	}
+	ast, err := parser.ParseString("testing", s)
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", s, err)
+	}
+	return ast
+}
+
+func TestParseModifierChain(t *testing.T) {
+	ast := parseString(`$gitlab_path.strip_last_prefix("helm-", "ansible-").lower().drop_first( "1" )`, t)
+	if len(ast.Parts) != 1 || len(ast.Parts[0].Pieces) != 1 || ast.Parts[0].Pieces[0].Var == nil {
+		t.Fatalf("Expected a single variable part, got %s", ast.String())
+	}
+	variable := ast.Parts[0].Pieces[0].Var
+	if variable.Name != "gitlab_path" {
+		t.Fatalf("Expected variable \"gitlab_path\", got \"%s\"", variable.Name)
+	}
+
+	expected := []struct {
+		Func string
+		Args []string
+	}{
+		{Func: "strip_last_prefix", Args: []string{`"helm-"`, `"ansible-"`}},
+		{Func: "lower"},
+		{Func: "drop_first", Args: []string{`"1"`}},
+	}
+	if len(variable.Modifiers) != len(expected) {
+		t.Fatalf("Expected %d modifiers, got %d", len(expected), len(variable.Modifiers))
+	}
+	for i, modifier := range variable.Modifiers {
+		if args := argumentStrings(modifier.Args); modifier.Func != expected[i].Func || !slices.Equal(args, expected[i].Args) {
+			t.Fatalf("Modifier %d: expected %s(%v), got %s(%v)", i, expected[i].Func, expected[i].Args, modifier.Func, args)
+		}
+	}
+}
+
+func argumentStrings(args []*Argument) []string {
+	var rendered []string
+	for _, arg := range args {
+		rendered = append(rendered, arg.String())
+	}
+	return rendered
+}
+
+func TestParseModifierArguments(t *testing.T) {
+	ast := parseString(`$path.f("a", 2, -1, true, false, [], ["x", "y"] ,[ "z" ])`, t)
+	args := ast.Parts[0].Pieces[0].Var.Modifiers[0].Args
+	expected := []string{`"a"`, "2", "-1", "true", "false", "[]", `["x", "y"]`, `["z"]`}
+	if rendered := argumentStrings(args); !slices.Equal(rendered, expected) {
+		t.Fatalf("Expected the arguments %v, got %v", expected, rendered)
+	}
+	if args[2].Int == nil || *args[2].Int != -1 || args[4].Bool == nil || bool(*args[4].Bool) || !args[5].List || args[5].Items != nil {
+		t.Fatalf("Expected typed arguments, got %v", args)
+	}
+
+	parser, err := NewParser()
+	if err != nil {
+		t.Fatalf("Cannot create parser: %v", err)
+	}
+	for _, schema := range []string{`$p.f(1, abc)`, `$p.f(1, -x)`, `$p.f(1, [1])`, `$p.f(["a",])`, `$p.f(["a"]`} {
+		if _, err := parser.ParseString("", schema); err == nil {
+			t.Fatalf("Expected %s to fail to parse", schema)
+		}
+	}
+}
+
+func TestParseVarSetModifiers(t *testing.T) {
+	ast := parseString(`$[technologies].lower().without("legacy")~i/$[projects].io/!$[reserved].upper()`, t)
+	if len(ast.Parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %d", len(ast.Parts))
+	}
+	piece := ast.Parts[0].Pieces[0]
+	if piece.VarSet == nil || len(piece.VarSet.Modifiers) != 2 || piece.VarSet.Modifiers[1].Func != "without" || piece.Flags != "i" {
+		t.Fatalf("Expected a variable set with two modifiers and a flag, got %s", ast.Parts[0].String())
+	}
+	if args := argumentStrings(piece.VarSet.Modifiers[1].Args); !slices.Equal(args, []string{`"legacy"`}) {
+		t.Fatalf("Expected the argument \"legacy\", got %v", args)
+	}
+	// A '.' which doesn't start a modifier is literal text
+	if pieces := ast.Parts[1].Pieces; len(pieces) != 2 || pieces[0].VarSet == nil || len(pieces[0].VarSet.Modifiers) != 0 || *pieces[1].Literal != ".io" {
+		t.Fatalf("Expected a variable set followed by the literal \".io\", got %s", ast.Parts[1].String())
+	}
+	if negation := ast.Parts[2].Negation; negation == nil || negation.VarSet == nil || len(negation.VarSet.Modifiers) != 1 {
+		t.Fatalf("Expected a negated variable set with a modifier, got %s", ast.Parts[2].String())
+	}
+}
+
+func TestParseCompositeSegment(t *testing.T) {
+	ast := parseString(`apps/app-${env}-db/$[technologies]_admin/+`, t)
+	if len(ast.Parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d", len(ast.Parts))
+	}
+
+	pieces := ast.Parts[1].Pieces
+	if len(pieces) != 3 {
+		t.Fatalf("Expected 3 pieces in the second part, got %s", ast.Parts[1].String())
+	}
+	if pieces[0].Literal == nil || *pieces[0].Literal != "app-" {
+		t.Fatalf("Expected literal \"app-\", got %s", pieces[0].String())
+	}
+	if pieces[1].Var == nil || pieces[1].Var.Name != "env" {
+		t.Fatalf("Expected variable \"env\", got %s", pieces[1].String())
+	}
+	if pieces[2].Literal == nil || *pieces[2].Literal != "-db" {
+		t.Fatalf("Expected literal \"-db\", got %s", pieces[2].String())
+	}
+
+	pieces = ast.Parts[2].Pieces
+	if len(pieces) != 2 || pieces[0].VarSet == nil || pieces[1].Literal == nil {
+		t.Fatalf("Expected a variable set followed by a literal, got %s", ast.Parts[2].String())
+	}
+}
+
+func TestParseCaptures(t *testing.T) {
+	ast := parseString(`+:role/$[technologies]:{tech}_admin/*{0,2}:rest`, t)
+	if len(ast.Parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %d", len(ast.Parts))
+	}
+	if ast.Parts[0].Wildcard == nil || ast.Parts[0].Wildcard.Capture == nil || ast.Parts[0].Wildcard.Capture.Name != "role" {
+		t.Fatalf("Expected a wildcard captured as \"role\", got %s", ast.Parts[0].String())
+	}
+	pieces := ast.Parts[1].Pieces
+	if len(pieces) != 2 || pieces[0].Capture == nil || pieces[0].Capture.Name != "tech" || pieces[1].Capture != nil {
+		t.Fatalf("Expected a variable set captured as \"tech\" followed by a literal, got %s", ast.Parts[1].String())
+	}
+	if ast.Parts[2].Wildcard == nil || ast.Parts[2].Wildcard.Capture == nil || ast.Parts[2].Wildcard.Capture.Name != "rest" {
+		t.Fatalf("Expected a quantified wildcard captured as \"rest\", got %s", ast.Parts[2].String())
+	}
+	if ast.Parts[0].Wildcard.Capture.Braced || !pieces[0].Capture.Braced {
+		t.Fatal("Expected only the capture of \"tech\" to be written with braces")
+	}
+}
+
+func TestParseSeparators(t *testing.T) {
+	ast := parseString(`docker.io/+:{name}:$[tags]/${env}.internal`, t)
+	expected := []string{"", "/", ":", "/"}
+	if len(ast.Parts) != len(expected) {
+		t.Fatalf("Expected %d parts, got %d", len(expected), len(ast.Parts))
+	}
+	for i, part := range ast.Parts {
+		if part.Separator != expected[i] {
+			t.Fatalf("Part %d: expected separator \"%s\", got \"%s\"", i, expected[i], part.Separator)
+		}
+	}
+	if ast.Parts[0].Pieces[0].Literal == nil || *ast.Parts[0].Pieces[0].Literal != "docker.io" {
+		t.Fatalf("Expected literal \"docker.io\", got %s", ast.Parts[0].String())
+	}
+	if ast.Parts[1].Wildcard == nil || ast.Parts[1].Wildcard.Capture == nil || ast.Parts[1].Wildcard.Capture.Name != "name" {
+		t.Fatalf("Expected a wildcard captured as \"name\", got %s", ast.Parts[1].String())
+	}
+	pieces := ast.Parts[3].Pieces
+	if len(pieces) != 2 || pieces[0].Var == nil || len(pieces[0].Var.Modifiers) != 0 || pieces[1].Literal == nil || *pieces[1].Literal != ".internal" {
+		t.Fatalf("Expected a variable followed by the literal \".internal\", got %s", ast.Parts[3].String())
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	ast := parseString(`(apps|infra/+:team|(a|b)/#[0-9]+#):kind/$[technologies]`, t)
+	if len(ast.Parts) != 2 || ast.Parts[0].Group == nil {
+		t.Fatalf("Expected a group followed by a variable set, got %s", ast.String())
+	}
+	group := ast.Parts[0].Group
+	if group.Capture == nil || group.Capture.Name != "kind" {
+		t.Fatalf("Expected the group to be captured as \"kind\", got %s", ast.Parts[0].String())
+	}
+	expected := []string{"apps", "infra/+:team", "(a|b)/#[0-9]+#"}
+	if len(group.Branches) != len(expected) {
+		t.Fatalf("Expected %d branches, got %d", len(expected), len(group.Branches))
+	}
+	for i, branch := range group.Branches {
+		if branch.Source() != expected[i] {
+			t.Fatalf("Branch %d: expected \"%s\", got \"%s\"", i, expected[i], branch.Source())
+		}
+	}
+	if group.Branches[2].Parts[0].Group == nil {
+		t.Fatalf("Expected a nested group, got %s", group.Branches[2].String())
+	}
+}
+
+func TestParseOptionalGroups(t *testing.T) {
+	ast := parseString(`deployment/(data)?/(metadata|data/+)??:kind/+`, t)
+	if len(ast.Parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d", len(ast.Parts))
+	}
+	if ast.Parts[1].Group == nil || ast.Parts[1].Group.Optional != "?" {
+		t.Fatalf("Expected an optional group, got %s", ast.Parts[1].String())
+	}
+	group := ast.Parts[2].Group
+	if group == nil || group.Optional != "??" || group.Capture == nil || group.Capture.Name != "kind" {
+		t.Fatalf("Expected an optional group preferring to be left out captured as \"kind\", got %s", ast.Parts[2].String())
+	}
+}
+
+func TestParseNegations(t *testing.T) {
+	ast := parseString(`!tmp:name/+!$[reserved]/*{0,2}!#^admin$#:rest/!(a|b/c)`, t)
+	if len(ast.Parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d", len(ast.Parts))
+	}
+	negation := ast.Parts[0].Negation
+	if negation == nil || negation.Literal == nil || *negation.Literal != "tmp" || negation.Capture == nil || negation.Capture.Name != "name" {
+		t.Fatalf("Expected a negated literal captured as \"name\", got %s", ast.Parts[0].String())
+	}
+	wildcard := ast.Parts[1].Wildcard
+	if wildcard == nil || wildcard.Exclusion == nil || wildcard.Exclusion.VarSet == nil {
+		t.Fatalf("Expected a wildcard excluding a variable set, got %s", ast.Parts[1].String())
+	}
+	wildcard = ast.Parts[2].Wildcard
+	if wildcard == nil || wildcard.Quantifier == nil || wildcard.Exclusion == nil || wildcard.Exclusion.Regex == nil || wildcard.Exclusion.Capture == nil {
+		t.Fatalf("Expected a quantified wildcard excluding a regex, got %s", ast.Parts[2].String())
+	}
+	if ast.Parts[3].Negation == nil || ast.Parts[3].Negation.Group == nil || len(ast.Parts[3].Negation.Group.Branches) != 2 {
+		t.Fatalf("Expected a negated group, got %s", ast.Parts[3].String())
+	}
+}
+
+func TestParseQuantifiers(t *testing.T) {
+	cases := []struct {
+		schema  string
+		minimum int
+		maximum int
+	}{
+		{"+{2}", 2, 2},
+		{"+{1,3}", 1, 3},
+		{"+{2,}", 2, -1},
+		{"*{,3}", 0, 3},
+		{"*{,}", 0, -1},
+		{"+{,3}", 1, 3},
+		{"+{,}", 1, -1},
+		{"+{0,2}", 0, 2},
+	}
+	for _, tc := range cases {
+		ast := parseString(tc.schema, t)
+		quantifier := ast.Parts[0].Wildcard.Quantifier
+		if quantifier == nil {
+			t.Fatalf("Expected a quantifier in %s", tc.schema)
+		}
+		minimum, maximum := ast.Parts[0].Wildcard.Bounds()
+		if minimum != tc.minimum || maximum != tc.maximum {
+			t.Fatalf("%s: expected bounds %d and %d, got %d and %d", tc.schema, tc.minimum, tc.maximum, minimum, maximum)
+		}
+	}
+}
+
+func TestParseBackrefs(t *testing.T) {
+	ast := parseString(`teams/+:team/owners/\team/\{team}-db/!\team/#^\k<team>-[0-9]+$#`, t)
+	if len(ast.Parts) != 7 {
+		t.Fatalf("Expected 7 parts, got %d", len(ast.Parts))
+	}
+	if backref := ast.Parts[3].Pieces[0].Backref; backref == nil || backref.Name != "team" {
+		t.Fatalf("Expected a backreference to \"team\", got %s", ast.Parts[3].String())
+	}
+	pieces := ast.Parts[4].Pieces
+	if len(pieces) != 2 || pieces[0].Backref == nil || pieces[1].Literal == nil || *pieces[1].Literal != "-db" {
+		t.Fatalf("Expected a backreference followed by the literal \"-db\", got %s", ast.Parts[4].String())
+	}
+	if negation := ast.Parts[5].Negation; negation == nil || negation.Backref == nil {
+		t.Fatalf("Expected a negated backreference, got %s", ast.Parts[5].String())
+	}
+	if regex := ast.Parts[6].Pieces[0].Regex; regex == nil || *regex != `^\k<team>-[0-9]+$` {
+		t.Fatalf("Expected a regex referring to \"team\", got %s", ast.Parts[6].String())
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	ast := parseString(`+:env/(?env=prod:$[prod_technologies]/+|$[technologies])/(?env=#^dev-#:debug)`, t)
+	if len(ast.Parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %d", len(ast.Parts))
+	}
+	condition := ast.Parts[1].Condition
+	if condition == nil || condition.Capture != "env" || condition.Literal == nil || *condition.Literal != "prod" {
+		t.Fatalf("Expected a condition on env being \"prod\", got %s", ast.Parts[1].String())
+	}
+	if condition.Then.Source() != "$[prod_technologies]/+" || condition.Else == nil || condition.Else.Source() != "$[technologies]" {
+		t.Fatalf("Expected both branches of the condition, got %s", ast.Parts[1].String())
+	}
+	condition = ast.Parts[2].Condition
+	if condition == nil || condition.Regex == nil || *condition.Regex != "^dev-" || condition.Else != nil {
+		t.Fatalf("Expected a condition on a regex without an else branch, got %s", ast.Parts[2].String())
+	}
+}
+
+func TestParseTypedSegments(t *testing.T) {
+	ast := parseString(`<uuid>:id/v<semver>/<int:1..99>-<date:2006-01>/!<dns_label>`, t)
+	if len(ast.Parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d", len(ast.Parts))
+	}
+	if piece := ast.Parts[0].Pieces[0]; piece.Type == nil || *piece.Type != "uuid" || piece.Capture == nil {
+		t.Fatalf("Expected a captured uuid, got %s", ast.Parts[0].String())
+	}
+	if pieces := ast.Parts[1].Pieces; len(pieces) != 2 || pieces[1].Type == nil || *pieces[1].Type != "semver" {
+		t.Fatalf("Expected the literal \"v\" followed by a semver, got %s", ast.Parts[1].String())
+	}
+	pieces := ast.Parts[2].Pieces
+	if len(pieces) != 3 || *pieces[0].Type != "int:1..99" || *pieces[2].Type != "date:2006-01" {
+		t.Fatalf("Expected an int range and a date, got %s", ast.Parts[2].String())
+	}
+	if negation := ast.Parts[3].Negation; negation == nil || negation.Type == nil || *negation.Type != "dns_label" {
+		t.Fatalf("Expected a negated DNS label, got %s", ast.Parts[3].String())
+	}
+	if source := ast.Source(); source != `<uuid>:id/v<semver>/<int:1..99>-<date:2006-01>/!<dns_label>` {
+		t.Fatalf("Expected the source to be rendered as written, got %s", source)
+	}
+}
+
+func TestParseWildcardLimits(t *testing.T) {
+	ast := parseString(`+<len 3..40, charset [a-z0-9-]>/*{1,3}<len ..63>:path`, t)
+	if len(ast.Parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(ast.Parts))
+	}
+	if limits := ast.Parts[0].Wildcard.Limits; limits == nil || *limits != "len 3..40, charset [a-z0-9-]" {
+		t.Fatalf("Expected limits on the first wildcard, got %s", ast.Parts[0].String())
+	}
+	wildcard := ast.Parts[1].Wildcard
+	if wildcard.Quantifier == nil || wildcard.Limits == nil || *wildcard.Limits != "len ..63" || wildcard.Capture == nil {
+		t.Fatalf("Expected a quantified wildcard with limits and a capture, got %s", ast.Parts[1].String())
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	ast := parseString(`secret~i/$env~i:env/$[technologies]~i/!tmp~i/app-${env}~i`, t)
+	if len(ast.Parts) != 5 {
+		t.Fatalf("Expected 5 parts, got %d", len(ast.Parts))
+	}
+	for _, i := range []int{0, 1, 2} {
+		if piece := ast.Parts[i].Pieces[0]; piece.Flags != "i" {
+			t.Fatalf("Expected the flag i, got %s", ast.Parts[i].String())
+		}
+	}
+	if capture := ast.Parts[1].Pieces[0].Capture; capture == nil || capture.Name != "env" {
+		t.Fatalf("Expected a capture after the flags, got %s", ast.Parts[1].String())
+	}
+	if negation := ast.Parts[3].Negation; negation == nil || negation.Flags != "i" {
+		t.Fatalf("Expected a negated literal with the flag i, got %s", ast.Parts[3].String())
+	}
+	if pieces := ast.Parts[4].Pieces; len(pieces) != 2 || pieces[0].Flags != "" || pieces[1].Flags != "i" {
+		t.Fatalf("Expected the flag on the variable only, got %s", ast.Parts[4].String())
+	}
+}
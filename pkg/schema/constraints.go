@@ -1,17 +1,24 @@
 package schema
 
 import (
-	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/hydridity/Schematic/pkg/parser"
 )
 
 // The basic interface of our constraints.
 type Constraint interface {
+	// Consume returns the input left over by the first way the constraint can match.
 	Consume([]string, *ValidationContext) ([]string, error)
+	// Match tries every way the constraint can consume a prefix of the input and passes each remainder to the
+	// continuation until one of them succeeds.
+	Match([]string, *ValidationContext, Continuation) error
 	String() string
 	GetVariableName() string
 }
@@ -19,21 +26,43 @@ type Constraint interface {
 // Concrete realization of our constraints.
 type LiteralConstraint struct {
 	Literal string
+	// CaseInsensitive compares the literal with the segment ignoring case, e.g. secret~i.
+	CaseInsensitive bool
+
+	// expected holds the literal for failures, so that they don't allocate it every time.
+	expected []string
 }
 
 type RegexConstraint struct {
+	// Pattern may refer to captures with \k<name>, which match the captured text literally.
 	Pattern string
+
+	// compiled is set by CompileConstraints unless the pattern refers to captures. Otherwise the pattern is compiled
+	// on every use.
+	compiled *regexp.Regexp
 }
 
+// captureReference matches a reference to a capture inside a regex, e.g. \k<team>.
+var captureReference = regexp.MustCompile(`\\k<([a-zA-Z_][a-zA-Z0-9_-]*)>`)
+
 type WildcardSingleConstraint struct {
 	Min int
+	// Max is negative when there is no upper bound, e.g. +{2,}.
 	Max int
+	// Limits restrict every consumed segment when set.
+	Limits *SegmentLimits
+}
+type WildcardMultiConstraint struct {
+	// Limits restrict every consumed segment when set.
+	Limits *SegmentLimits
 }
-type WildcardMultiConstraint struct{}
 
 type VariableModifier struct {
 	FuncName string
-	Args     []string
+	Args     []ModifierArg
+
+	// spec is the registered modifier the call was checked against when the schema was created.
+	spec *ModifierSpec
 }
 type VariableModifierInstance struct {
 	Modifier VariableModifier
@@ -42,44 +71,167 @@ type VariableModifierInstance struct {
 type VariableConstraint struct {
 	VariableName string
 	Modifiers    []VariableModifier
+	// CaseInsensitive compares the modified value with the input ignoring case, e.g. $gitlab_path~i.
+	CaseInsensitive bool
 }
 
 type VariableSetConstraint struct {
 	VariableName string
+	// Modifiers are applied to the members before they are compiled, e.g. $[technologies].lower().without("legacy").
+	Modifiers []VariableModifier
+
+	// options are passed on to the members, which are compiled as schemas of their own. The input is only split at
+	// the separators of the enclosing schema though, so members can't add separators to it.
+	options SchemaOptions
+
+	// members caches the compiled members of the last seen contents of the set.
+	members atomic.Pointer[compiledVariableSet]
+	// schemas holds every member compiled so far, so that alternating stores with different contents of the set
+	// don't compile their members again. It's cleared once it holds maxCachedMembers.
+	schemasMutex sync.Mutex
+	schemas      map[string]Schema
+}
+
+// maxCachedMembers bounds the compiled members a variable set keeps, as its contents may change without limit.
+const maxCachedMembers = 1024
+
+type compiledVariableSet struct {
+	members []string
+	schemas []Schema
+}
+
+// CompositeConstraint matches a single segment made of several pieces, e.g. app-${env}-db. Each piece is a
+// constraint which has to match its part of the segment as if it were a whole segment on its own.
+type CompositeConstraint struct {
+	Pieces []Constraint
+}
+
+// BackreferenceConstraint matches the same segments as the latest capture with the name, e.g. \team.
+type BackreferenceConstraint struct {
+	Name            string
+	CaseInsensitive bool
+}
+
+// GroupConstraint matches any one of its branches, e.g. (apps|infra/+). Each branch is a schema of its own which
+// may span several segments.
+type GroupConstraint struct {
+	Branches []Schema
+	// Optional groups may also match nothing, e.g. (data)?.
+	Optional bool
+	// PreferOmitted tries to match nothing before trying the branches of an optional group, e.g. (data)??. It decides
+	// which form is reported when the input matches both with and without the group.
+	PreferOmitted bool
+
+	// sources holds the text of every branch, which is reported as the member that matched.
+	sources []string
+}
+
+// ConditionalConstraint matches Then when the latest value of the capture equals Value, or matches Pattern when
+// it's set, and Else otherwise, e.g. (?env=prod:$[prod_technologies]|$[technologies]). Without Else it matches
+// nothing when the condition doesn't hold.
+type ConditionalConstraint struct {
+	Capture string
+	Value   string
+	Pattern *regexp.Regexp
+	Then    Schema
+	Else    Schema
+	// CaseInsensitive compares the captured value with Value ignoring case.
+	CaseInsensitive bool
+
+	// sources holds the text of both branches, which is reported as the member that matched.
+	sources [2]string
+}
+
+// NegatedConstraint matches what Constraint matches, unless the Negated constraint matches the same segments as
+// a whole, e.g. +!$[reserved], or !tmp which is short for +!tmp.
+type NegatedConstraint struct {
+	Constraint Constraint
+	Negated    Constraint
+}
+
+// CaptureConstraint records the input consumed by another constraint under a name, e.g. +:role.
+type CaptureConstraint struct {
+	Name       string
+	Constraint Constraint
 }
 
 func (c *LiteralConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *LiteralConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
 	if len(path) <= 0 {
-		return nil, errors.New("empty path")
+		return newValidationError(c, path, ReasonTooShort, []string{c.Literal}, "empty path")
 	}
-	if path[0] != c.Literal {
-		return nil, fmt.Errorf("expected '%s', got '%s'", c.Literal, path[0])
+	if !equalSegments(path[0], c.Literal, c.CaseInsensitive) {
+		expected := c.expected
+		if expected == nil {
+			expected = []string{c.Literal}
+		}
+		return newMismatchError(c, path, ReasonLiteralMismatch, expected)
 	}
-	return path[1:], nil
+	return context.state.advance(c, path, path[1:], "", next)
 }
 
 func (c *LiteralConstraint) String() string {
+	if c.CaseInsensitive {
+		return fmt.Sprintf("LiteralConstraint(%s~i)", c.Literal)
+	}
 	return fmt.Sprintf("LiteralConstraint(%s)", c.Literal)
 }
 
+// equalSegments compares a segment with the text expected by a constraint, ignoring case if asked to.
+func equalSegments(segment string, expected string, caseInsensitive bool) bool {
+	if caseInsensitive {
+		return strings.EqualFold(segment, expected)
+	}
+	return segment == expected
+}
+
 func (c *LiteralConstraint) GetVariableName() string {
 	return "" // Literal constraints do not have a variable name
 }
 
 func (c *RegexConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *RegexConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
 	if len(path) <= 0 {
-		return nil, errors.New("empty path")
+		return newValidationError(c, path, ReasonTooShort, []string{c.Pattern}, "empty path")
 	}
 
-	pattern, err := regexp.Compile(c.Pattern)
-	if err != nil {
-		return nil, err
+	pattern := c.compiled
+	if pattern == nil {
+		expanded, err := c.expand(path, context)
+		if err != nil {
+			return err
+		}
+		pattern, err = regexp.Compile(expanded)
+		if err != nil {
+			return newValidationError(c, path, ReasonInvalidRegex, nil, "%v", err)
+		}
 	}
 
 	if !pattern.MatchString(path[0]) {
-		return nil, fmt.Errorf("expected '%s', does not match regex '%s'", path[0], c.Pattern)
+		return newValidationError(c, path, ReasonRegexMismatch, []string{c.Pattern}, "'%s' does not match regex '%s'", path[0], c.Pattern)
 	}
-	return path[1:], nil
+	return context.state.advance(c, path, path[1:], "", next)
+}
+
+// expand replaces the references to captures in the pattern with the captured text.
+func (c *RegexConstraint) expand(path []string, context *ValidationContext) (string, error) {
+	var err error
+	expanded := captureReference.ReplaceAllStringFunc(c.Pattern, func(reference string) string {
+		name := captureReference.FindStringSubmatch(reference)[1]
+		captured, found := context.state.captured(name)
+		if !found {
+			err = newValidationError(c, path, ReasonMissingCapture, nil, "capture '%s' has not matched anything", name)
+			return ""
+		}
+		return regexp.QuoteMeta(strings.Join(captured, context.state.separatorOrDefault()))
+	})
+	return expanded, err
 }
 
 func (c *RegexConstraint) String() string {
@@ -91,27 +243,48 @@ func (c *RegexConstraint) GetVariableName() string {
 }
 
 func (c *WildcardSingleConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
-	// if len(path) <= 0 {
-	// 	return nil, errors.New("empty path")
-	// }
-	//schema: literal1/+{0,2}
-	//path [literal1/test]
+	return consumeFirst(c, path, context)
+}
+
+// Match tries every allowed number of segments, starting with the largest one, until the rest of the schema accepts
+// the remainder.
+func (c *WildcardSingleConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
 	if len(path) < c.Min {
-		return nil, errors.New(fmt.Sprintf("not enough segments for quantified wildcard: need at least %d", c.Min))
+		return newValidationError(c, path, ReasonTooShort, nil, "not enough segments for quantified wildcard: need at least %d", c.Min)
 	}
 
-	if len(path) >= c.Max {
-		return path[c.Max:], nil
+	upper := len(path)
+	if c.Max >= 0 {
+		upper = min(c.Max, upper)
 	}
-
-	if len(path) >= c.Min && len(path) <= c.Max {
-		return []string{}, nil
+	upper, rejected := acceptedSegments(c, c.Limits, path, upper)
+	upper, rejected = context.state.joinedSegments(c, path, upper, rejected)
+	if upper < c.Min {
+		return rejected
 	}
 
-	return path[1:], nil
+	var best error
+	for n := upper; n >= c.Min; n-- {
+		err := context.state.advance(c, path, path[n:], "", next)
+		if err == nil {
+			return nil
+		}
+		best = pickError(best, err)
+	}
+	if rejected != nil {
+		// The rest of the schema may have failed only because the wildcard couldn't consume the rejected segment
+		best = pickError(best, rejected)
+	}
+	if best == nil {
+		return newValidationError(c, path, ReasonTooShort, nil, "quantified wildcard cannot consume between %d and %d segments", c.Min, c.Max)
+	}
+	return best
 }
 
 func (c *WildcardSingleConstraint) String() string {
+	if c.Limits != nil {
+		return "WildcardSingleConstraint" + c.Limits.String()
+	}
 	return "WildcardSingleConstraint"
 }
 
@@ -120,10 +293,29 @@ func (c *WildcardSingleConstraint) GetVariableName() string {
 }
 
 func (c *WildcardMultiConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
-	return []string{}, nil
+	return consumeFirst(c, path, context)
+}
+
+// Match tries to consume every possible number of segments, starting with all of them.
+func (c *WildcardMultiConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	upper, rejected := acceptedSegments(c, c.Limits, path, len(path))
+	upper, rejected = context.state.joinedSegments(c, path, upper, rejected)
+	var best error
+	for n := upper; n >= 0; n-- {
+		err := context.state.advance(c, path, path[n:], "", next)
+		if err == nil {
+			return nil
+		}
+		best = pickError(best, err)
+	}
+	// The rest of the schema may have failed only because the wildcard couldn't consume the rejected segment
+	return pickError(best, rejected)
 }
 
 func (c *WildcardMultiConstraint) String() string {
+	if c.Limits != nil {
+		return "WildcardMultiConstraint" + c.Limits.String()
+	}
 	return "WildcardMultiConstraint"
 }
 
@@ -132,51 +324,164 @@ func (c *WildcardMultiConstraint) GetVariableName() string {
 }
 
 func (c *VariableConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+// Match applies the modifiers to the variable value and matches the result. When modifiers yield several
+// candidate values, each is tried in order, and a later one is still tried when an earlier one matched but the rest
+// of the schema did not.
+func (c *VariableConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
 	if len(path) <= 0 {
-		return nil, errors.New("empty path")
+		return newValidationError(c, path, ReasonTooShort, nil, "empty path")
 	}
 	variable, found := context.VariableStore.GetVariable(c.VariableName)
 	if !found {
-		return nil, fmt.Errorf("variable '%s' not found in store", c.VariableName)
+		return newValidationError(c, path, ReasonMissingVariable, nil, "variable '%s' not found in store", c.VariableName)
 	}
 
-	// Get modifier functions referenced in the constraint
-	modifierInstances := make([]VariableModifierInstance, 0, len(c.Modifiers))
+	// Apply the modifier functions referenced in the constraint to the variable in order
+	separator := context.state.separatorOrDefault()
+	candidates := [][]string{strings.Split(variable, separator)}
+	traced := context.state.newTracedVariable(c.VariableName, variable)
 	for _, modifier := range c.Modifiers {
-		fun, found := context.VariableModifiers[modifier.FuncName]
-		if !found {
-			return nil, fmt.Errorf("modifier '%s' not found in context modifiers", modifier.FuncName)
+		if function := modifier.function(context); function != nil && len(candidates) == 1 {
+			// A single value is modified without allocating candidates
+			result, err := function(candidates[0], modifier.Args)
+			if err != nil {
+				return c.modifierFailed(modifier, err, traced, path, context)
+			}
+			candidates[0] = result
+			traced.addModifier(modifier, candidates, nil)
+			continue
 		}
-		modifierInstances = append(modifierInstances, VariableModifierInstance{
-			Modifier: modifier,
-			Function: fun,
-		})
-	}
 
-	// Apply the modifier functions to the variable in order
-	var err error
-	parts := strings.Split(variable, "/")
-	for _, mod := range modifierInstances {
-		parts, err = mod.Function(parts, mod.Modifier.Args)
-		if err != nil {
-			return nil, fmt.Errorf("modifier '%s' application failed: %v", mod.Modifier.FuncName, err)
+		results := make([][]string, 0, len(candidates))
+		for _, parts := range candidates {
+			if len(candidates) > 1 {
+				// Modifiers may modify the slice in place, which candidates could share
+				parts = slices.Clone(parts)
+			}
+			modified, found, err := modifier.apply(parts, context)
+			if !found {
+				return newValidationError(c, path, ReasonMissingModifier, nil, "modifier '%s' not found in context modifiers", modifier.FuncName)
+			}
+			if err == nil && len(modified) == 0 {
+				err = fmt.Errorf("no candidate values")
+			}
+			if err != nil {
+				return c.modifierFailed(modifier, err, traced, path, context)
+			}
+			results = appendCandidates(results, modified)
 		}
+		candidates = results
+		traced.addModifier(modifier, candidates, nil)
 	}
 
-	// Validate the input with modified variable parts
+	context.state.traceVariable(path, traced)
 
-	if len(path) < len(parts) {
-		return nil, fmt.Errorf("path too short for variable '%s'", variable)
+	// Validate the input with modified variable parts
+	if len(candidates) == 1 {
+		return c.matchParts(candidates[0], variable, separator, "", path, context, next)
 	}
+	var best error
+	for _, parts := range candidates {
+		err := c.matchParts(parts, variable, separator, strings.Join(parts, separator), path, context, next)
+		if err == nil {
+			return nil
+		}
+		best = pickError(best, err)
+	}
+	return best
+}
+
+func (c *VariableConstraint) modifierFailed(modifier VariableModifier, err error, traced *TracedVariable, path []string, context *ValidationContext) error {
+	traced.addModifier(modifier, nil, err)
+	context.state.traceVariable(path, traced)
+	return newValidationError(c, path, ReasonModifierFailed, nil, "modifier '%s' application failed: %w", modifier.FuncName, err)
+}
+
+// matchParts matches one candidate value of the variable, reporting the member when there are several candidates.
+func (c *VariableConstraint) matchParts(parts []string, variable string, separator string, member string, path []string, context *ValidationContext, next Continuation) error {
 	for i, part := range parts {
-		if path[i] != part {
-			return nil, fmt.Errorf("invalid variable constraint value at part %d, variable '%s'", i, variable)
+		if i >= len(path) {
+			return newValidationError(c, path[i:], ReasonTooShort, []string{part}, "path too short for variable '%s'", variable)
+		}
+		if !equalSegments(path[i], part, c.CaseInsensitive) {
+			return newValidationError(c, path[i:], ReasonVariableMismatch, []string{part}, "invalid variable constraint value at part %d, variable '%s'", i, variable)
+		}
+		if i > 0 && context.state != nil {
+			if delimiter := context.state.delimiter(len(context.state.segments) - len(path) + i); delimiter != separator {
+				return newValidationError(c, path[i:], ReasonSeparatorMismatch, []string{separator}, "expected separator '%s' in front of '%s', got '%s'", separator, part, delimiter)
+			}
+		}
+	}
+	return context.state.advance(c, path, path[len(parts):], member, next)
+}
+
+// appendCandidates adds the candidate values which aren't there yet.
+func appendCandidates(candidates [][]string, values [][]string) [][]string {
+	for _, value := range values {
+		if !slices.ContainsFunc(candidates, func(candidate []string) bool { return slices.Equal(candidate, value) }) {
+			candidates = append(candidates, value)
+		}
+	}
+	return candidates
+}
+
+// apply applies the modifier to the variable, returning its candidate values. Modifiers in the context take
+// precedence and get their arguments as text, otherwise the registered modifier the call was checked against is
+// used, or the predefined one.
+func (m *VariableModifier) apply(variable []string, context *ValidationContext) ([][]string, bool, error) {
+	if fun, found := context.VariableModifiers[m.FuncName]; found {
+		result, err := fun(variable, stringArgs(m.Args))
+		return [][]string{result}, true, err
+	}
+	spec := m.spec
+	if spec == nil {
+		var found bool
+		if spec, found = predefinedModifiers.Lookup(m.FuncName); !found {
+			return nil, false, nil
+		}
+		if err := spec.check(m.Args); err != nil {
+			return nil, true, err
+		}
+	}
+	result, err := spec.call(variable, m.Args)
+	return result, true, err
+}
+
+// function returns the function of a registered modifier yielding a single value, or nil for any other modifier.
+// Modifiers in the context take precedence.
+func (m *VariableModifier) function(context *ValidationContext) ModifierFunction {
+	if _, found := context.VariableModifiers[m.FuncName]; found || m.spec == nil {
+		return nil
+	}
+	return m.spec.Function
+}
+
+// setFunction returns the function of a set modifier, or nil for a modifier of single values. Modifiers in the
+// context take precedence and always modify single values.
+func (m *VariableModifier) setFunction(context *ValidationContext) (SetModifierFunction, error) {
+	if _, found := context.VariableModifiers[m.FuncName]; found {
+		return nil, nil
+	}
+	spec := m.spec
+	if spec == nil {
+		var found bool
+		if spec, found = predefinedModifiers.Lookup(m.FuncName); !found || spec.Set == nil {
+			return nil, nil
+		}
+		if err := spec.check(m.Args); err != nil {
+			return nil, err
 		}
 	}
-	return path[len(parts):], nil
+	return spec.Set, nil
 }
 
 func (c *VariableConstraint) String() string {
+	if c.CaseInsensitive {
+		return fmt.Sprintf("VariableConstraint(%s~i)", c.VariableName)
+	}
 	return fmt.Sprintf("VariableConstraint(%s)", c.VariableName)
 }
 
@@ -185,42 +490,153 @@ func (c *VariableConstraint) GetVariableName() string {
 }
 
 func (c *VariableSetConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+// Match tries every member of the set as a sub-schema followed by the rest of the schema. Members are tried in
+// the order they are defined in, and a later member is still tried when an earlier one matched but the rest of the
+// schema did not.
+func (c *VariableSetConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
 	if len(path) <= 0 {
-		return nil, errors.New("empty path")
+		return newValidationError(c, path, ReasonTooShort, nil, "empty path")
 	}
 
 	variable, found := context.VariableStore.GetVariableSet(c.VariableName)
 	if !found {
-		return nil, fmt.Errorf("variable '%s' not found in store", c.VariableName)
+		return newValidationError(c, path, ReasonMissingVariableSet, nil, "variable set '%s' not found in store", c.VariableName)
 	}
 
 	if len(variable) == 0 {
-		return nil, fmt.Errorf("variable set '%s' is empty", c.VariableName)
+		return newValidationError(c, path, ReasonEmptyVariableSet, nil, "variable set '%s' is empty", c.VariableName)
 	}
-
-	foundInSet := false
-	for _, v := range variable {
-		subSchemaInput := make([]string, len(path))
-		copy(subSchemaInput, path)
-		subSchemaCompiled, err := CreateSchema(v)
+	if len(c.Modifiers) > 0 {
+		modified, err := c.applyModifiers(variable, path, context)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		if len(modified) == 0 {
+			return newValidationError(c, path, ReasonEmptyVariableSet, nil, "variable set '%s' has no members left after its modifiers", c.VariableName)
+		}
+		variable = modified
+	}
+
+	compiledSet, err := c.compileMembers(variable)
+	if err != nil {
+		return newValidationError(c, path, ReasonInvalidSetMember, nil, "%w", err)
+	}
 
-		subSchemaInput, err = subSchemaCompiled.consume(subSchemaInput, context)
+	// One continuation serves every member, which it reads from the loop variables
+	var best error
+	var value string
+	var member *TraceNode
+	continuation := func(rest []string) error {
+		context.state.leave()
+		defer context.state.enter(member)
+		return context.state.advance(c, path, rest, value, next)
+	}
+	for i, v := range variable {
+		subSchemaCompiled := compiledSet.schemas[i]
 
+		value = v
+		member = context.state.traceMember(c, path, v)
+		context.state.enter(member)
+		err = subSchemaCompiled.match(path, context, continuation)
+		context.state.leave()
+		context.state.traceMemberResult(member, err)
 		if err == nil {
-			foundInSet = true
-			path = subSchemaInput
-			break
+			return nil
 		}
+		best = pickError(best, err)
 	}
 
-	if !foundInSet {
-		return nil, errors.New("invalid variable set constraint value")
+	// A failure after some part of a member matched says more than the set miss itself.
+	if bestErr, ok := best.(*ValidationError); ok && (bestErr.progress > context.state.currentProgress() || bestErr.remaining < len(path)) {
+		return best
+	}
+	return newValidationError(c, path, ReasonSetMiss, variable, "'%s' is not a member of variable set '%s'", path[0], c.VariableName)
+}
+
+// applyModifiers applies the modifiers to the members of the set in order. Set modifiers get all members at once,
+// while the others modify each member, whose segments are separated by '/' as in any schema.
+func (c *VariableSetConstraint) applyModifiers(members []string, path []string, context *ValidationContext) ([]string, error) {
+	traced := context.state.newTracedVariable(c.VariableName, strings.Join(members, ", "))
+	if traced != nil {
+		traced.Set = true
 	}
+	defer context.state.traceVariable(path, traced)
 
-	return path, nil
+	for _, modifier := range c.Modifiers {
+		set, err := modifier.setFunction(context)
+		found := true
+		if set != nil && err == nil {
+			members, err = set(slices.Clone(members), modifier.Args)
+		} else if err == nil {
+			members, found, err = modifyMembers(modifier, members, context)
+		}
+		if !found {
+			return nil, newValidationError(c, path, ReasonMissingModifier, nil, "modifier '%s' not found in context modifiers", modifier.FuncName)
+		}
+		if err != nil {
+			traced.addModifier(modifier, nil, err)
+			return nil, newValidationError(c, path, ReasonModifierFailed, nil, "modifier '%s' application failed: %w", modifier.FuncName, err)
+		}
+		traced.addModifier(modifier, [][]string{members}, nil)
+	}
+	return members, nil
+}
+
+// modifyMembers applies a modifier of single values to every member. A modifier yielding several candidate values
+// makes each of them a member.
+func modifyMembers(modifier VariableModifier, members []string, context *ValidationContext) ([]string, bool, error) {
+	modified := make([]string, 0, len(members))
+	for _, member := range members {
+		candidates, found, err := modifier.apply(strings.Split(member, "/"), context)
+		if !found {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("member '%s': %w", member, err)
+		}
+		for _, candidate := range candidates {
+			if value := strings.Join(candidate, "/"); !slices.Contains(modified, value) {
+				modified = append(modified, value)
+			}
+		}
+	}
+	return modified, true, nil
+}
+
+// compileMembers returns the members of the set compiled as schemas. The members of the last call are at hand
+// without allocating, while those of other calls are put together from the members compiled before, e.g. when
+// several variable stores are used in turn. Only members which were never seen are compiled.
+func (c *VariableSetConstraint) compileMembers(members []string) (*compiledVariableSet, error) {
+	cached := c.members.Load()
+	if cached != nil && slices.Equal(cached.members, members) {
+		return cached, nil
+	}
+
+	c.schemasMutex.Lock()
+	defer c.schemasMutex.Unlock()
+	if c.schemas == nil || len(c.schemas)+len(members) > maxCachedMembers {
+		c.schemas = make(map[string]Schema, len(members))
+	}
+	compiled := &compiledVariableSet{
+		members: slices.Clone(members),
+		schemas: make([]Schema, 0, len(members)),
+	}
+	for _, member := range members {
+		memberSchema, found := c.schemas[member]
+		if !found {
+			var err error
+			if memberSchema, err = CreateSchemaWithOptions(member, c.options); err != nil {
+				return nil, fmt.Errorf("invalid member '%s' of variable set '%s': %w", member, c.VariableName, err)
+			}
+			c.schemas[member] = memberSchema
+		}
+		compiled.schemas = append(compiled.schemas, memberSchema)
+	}
+	c.members.Store(compiled)
+	return compiled, nil
 }
 
 func (c *VariableSetConstraint) String() string {
@@ -231,48 +647,529 @@ func (c *VariableSetConstraint) GetVariableName() string {
 	return c.VariableName
 }
 
+func (c *CompositeConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *CompositeConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	if len(path) <= 0 {
+		return newValidationError(c, path, ReasonTooShort, nil, "empty path")
+	}
+
+	// Captures inside the pieces stay recorded after the pieces matched, so they have to be dropped by hand when
+	// the rest of the schema fails.
+	mark := context.state.mark()
+	pieces := context.state.tracePieces(c, path)
+	context.state.enter(pieces)
+	matched, missing := matchPieces(c.Pieces, path[0], context)
+	context.state.leave()
+	pieces.moveToSegment()
+	if missing != nil {
+		context.state.reset(mark)
+		return newValidationError(missing.Constraint, path, missing.Reason, nil, missing.format, missing.args...)
+	}
+	if !matched {
+		return newValidationError(c, path, ReasonCompositeMismatch, nil, "segment '%s' does not match composite segment", path[0])
+	}
+	err := context.state.advance(c, path, path[1:], "", next)
+	if err != nil {
+		context.state.reset(mark)
+	}
+	return err
+}
+
+// matchPieces splits the segment between the pieces, trying the longest text for each piece first. A piece
+// referring to a variable or a set which isn't in the store fails every split, so that failure is returned instead.
+func matchPieces(pieces []Constraint, segment string, context *ValidationContext) (bool, *ValidationError) {
+	if len(pieces) == 0 {
+		return segment == "", nil
+	}
+	for end := len(segment); end >= 0; end-- {
+		if end < len(segment) && !utf8.RuneStart(segment[end]) {
+			continue
+		}
+		if context.state.spend() != nil {
+			return false, nil
+		}
+		mark := context.state.mark()
+		err := matchWholeSegment(pieces[0], segment[:end], context)
+		if validationErr, ok := err.(*ValidationError); ok && (validationErr.Reason == ReasonMissingVariable || validationErr.Reason == ReasonMissingVariableSet) {
+			return false, validationErr
+		}
+		if err == nil {
+			matched, missing := matchPieces(pieces[1:], segment[end:], context)
+			if matched || missing != nil {
+				return matched, missing
+			}
+		}
+		context.state.reset(mark)
+	}
+	return false, nil
+}
+
+func matchWholeSegment(constraint Constraint, segment string, context *ValidationContext) error {
+	return constraint.Match([]string{segment}, context, func(rest []string) error {
+		if len(rest) > 0 {
+			return newValidationError(constraint, rest, ReasonTrailingSegments, nil, "piece does not cover the whole text")
+		}
+		return nil
+	})
+}
+
+func (c *CompositeConstraint) String() string {
+	pieces := make([]string, 0, len(c.Pieces))
+	for _, piece := range c.Pieces {
+		pieces = append(pieces, piece.String())
+	}
+	return fmt.Sprintf("CompositeConstraint(%s)", strings.Join(pieces, ", "))
+}
+
+func (c *CompositeConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *BackreferenceConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *BackreferenceConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	captured, found := context.state.captured(c.Name)
+	if !found {
+		return newValidationError(c, path, ReasonMissingCapture, nil, "capture '%s' has not matched anything", c.Name)
+	}
+	for i, segment := range captured {
+		if i >= len(path) {
+			return newValidationError(c, path[i:], ReasonTooShort, []string{segment}, "path too short for capture '%s'", c.Name)
+		}
+		if !equalSegments(path[i], segment, c.CaseInsensitive) {
+			return newValidationError(c, path[i:], ReasonBackreferenceMismatch, []string{segment}, "expected '%s' as captured by '%s', got '%s'", segment, c.Name, path[i])
+		}
+	}
+	return context.state.advance(c, path, path[len(captured):], "", next)
+}
+
+func (c *BackreferenceConstraint) String() string {
+	return fmt.Sprintf("BackreferenceConstraint(%s)", c.Name)
+}
+
+func (c *BackreferenceConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *GroupConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+// Match tries every branch followed by the rest of the schema, in the order they are written in. A later branch is
+// still tried when an earlier one matched but the rest of the schema did not. Optional groups also try to match
+// nothing, after the branches or before them.
+func (c *GroupConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	var best error
+	if c.Optional && c.PreferOmitted {
+		best = context.state.advance(c, path, path, "", next)
+		if best == nil {
+			return nil
+		}
+	}
+	for i, branch := range c.Branches {
+		err := matchBranch(c, branch, c.sources[i], path, context, next)
+		if err == nil {
+			return nil
+		}
+		best = pickError(best, err)
+	}
+	if c.Optional && !c.PreferOmitted {
+		err := context.state.advance(c, path, path, "", next)
+		if err == nil {
+			return nil
+		}
+		best = pickError(best, err)
+	}
+
+	// A failure after some part of a branch matched says more than the group miss itself.
+	if bestErr, ok := best.(*ValidationError); len(path) == 0 || ok && (bestErr.progress > context.state.currentProgress() || bestErr.remaining < len(path)) {
+		return best
+	}
+	return newValidationError(c, path, ReasonGroupMiss, c.sources, "'%s' does not match any branch of the group", path[0])
+}
+
+// matchBranch matches a schema inside the constraint, followed by the rest of the schema. The source of the branch
+// is reported as the member that matched.
+func matchBranch(c Constraint, branch Schema, source string, path []string, context *ValidationContext, next Continuation) error {
+	member := context.state.traceMember(c, path, source)
+	context.state.enter(member)
+	err := branch.match(path, context, func(rest []string) error {
+		context.state.leave()
+		defer context.state.enter(member)
+		return context.state.advance(c, path, rest, source, next)
+	})
+	context.state.leave()
+	context.state.traceMemberResult(member, err)
+	return err
+}
+
+func (c *GroupConstraint) String() string {
+	optional := ""
+	switch {
+	case c.Optional && c.PreferOmitted:
+		optional = "??"
+	case c.Optional:
+		optional = "?"
+	}
+	return fmt.Sprintf("GroupConstraint(%s)%s", strings.Join(c.sources, " | "), optional)
+}
+
+func (c *GroupConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *ConditionalConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *ConditionalConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	if c.holds(context) {
+		return matchBranch(c, c.Then, c.sources[0], path, context, next)
+	}
+	if c.Else == nil {
+		return context.state.advance(c, path, path, "", next)
+	}
+	return matchBranch(c, c.Else, c.sources[1], path, context, next)
+}
+
+// holds reports whether the condition is met. It isn't when the capture has not matched anything.
+func (c *ConditionalConstraint) holds(context *ValidationContext) bool {
+	captured, found := context.state.captured(c.Capture)
+	if !found {
+		return false
+	}
+	value := strings.Join(captured, context.state.separatorOrDefault())
+	if c.Pattern != nil {
+		return c.Pattern.MatchString(value)
+	}
+	return equalSegments(value, c.Value, c.CaseInsensitive)
+}
+
+func (c *ConditionalConstraint) String() string {
+	condition := c.Value
+	if c.Pattern != nil {
+		condition = "#" + c.Pattern.String() + "#"
+	}
+	if c.Else == nil {
+		return fmt.Sprintf("ConditionalConstraint(%s=%s ? %s)", c.Capture, condition, c.sources[0])
+	}
+	return fmt.Sprintf("ConditionalConstraint(%s=%s ? %s : %s)", c.Capture, condition, c.sources[0], c.sources[1])
+}
+
+func (c *ConditionalConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *NegatedConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *NegatedConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	return c.Constraint.Match(path, context, func(rest []string) error {
+		if c.negatedMatches(path, rest, context) {
+			consumed := context.state.joinConsumed(path, rest)
+			err := newValidationError(c, path, ReasonExcluded, nil, "'%s' is excluded by %s", consumed, c.Negated.String())
+			return context.state.stamp(err)
+		}
+		return next(rest)
+	})
+}
+
+// negatedMatches reports whether the negated constraint can consume exactly the input between path and rest.
+// Nothing it records while trying is kept.
+func (c *NegatedConstraint) negatedMatches(path []string, rest []string, context *ValidationContext) bool {
+	mark := context.state.mark()
+	context.state.enter(nil)
+	err := c.Negated.Match(path, context, func(negatedRest []string) error {
+		if len(negatedRest) != len(rest) {
+			return newValidationError(c.Negated, negatedRest, ReasonTrailingSegments, nil, "negated constraint does not cover the same segments")
+		}
+		return nil
+	})
+	context.state.leave()
+	context.state.reset(mark)
+	return err == nil
+}
+
+func (c *NegatedConstraint) String() string {
+	return fmt.Sprintf("NegatedConstraint(%s, %s)", c.Constraint.String(), c.Negated.String())
+}
+
+func (c *NegatedConstraint) GetVariableName() string {
+	return c.Negated.GetVariableName()
+}
+
+func (c *CaptureConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *CaptureConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	return c.Constraint.Match(path, context, func(rest []string) error {
+		return context.state.capture(c.Name, path, rest, func() error {
+			return next(rest)
+		})
+	})
+}
+
+func (c *CaptureConstraint) String() string {
+	return fmt.Sprintf("CaptureConstraint(%s, %s)", c.Name, c.Constraint.String())
+}
+
+func (c *CaptureConstraint) GetVariableName() string {
+	return c.Constraint.GetVariableName()
+}
+
+// CompileConstraints compiles a parsed schema with the default options.
+//
+// It returns nil when any part of the schema fails to compile: a regex, a typed segment, a flag or a modifier call.
+// Earlier versions compiled such schemas and only failed once an invalid regex was matched. A nil result is not an
+// empty schema, so callers have to check for it, and CompileConstraintsWithOptions reports what is invalid.
+//
+// Deprecated: use CompileConstraintsWithOptions, which reports why a schema is invalid.
 func CompileConstraints(schemaAst *parser.SchemaAST) []Constraint {
+	constraints, err := compileConstraints(schemaAst, SchemaOptions{})
+	if err != nil {
+		return nil
+	}
+	return constraints
+}
+
+// CompileConstraintsWithOptions compiles a parsed schema, failing when a regex, typed segment or modifier call in it
+// is invalid.
+func CompileConstraintsWithOptions(schemaAst *parser.SchemaAST, options SchemaOptions) ([]Constraint, error) {
+	return compileConstraints(schemaAst, options)
+}
+
+func compileConstraints(schemaAst *parser.SchemaAST, options SchemaOptions) ([]Constraint, error) {
 	constraints := make([]Constraint, 0, len(schemaAst.Parts))
 
 	for _, part := range schemaAst.Parts {
 		switch {
 
-		case part.Var != nil:
-			modifiers := make([]VariableModifier, 0)
-			if part.Var.Modifier != nil {
-				modifiers = append(modifiers, VariableModifier{
-					FuncName: part.Var.Modifier.Func,
-					Args:     part.Var.Modifier.Args,
-				})
+		case part.Wildcard != nil:
+			var limits *SegmentLimits
+			if part.Wildcard.Limits != nil {
+				var err error
+				limits, err = parseSegmentLimits(*part.Wildcard.Limits)
+				if err != nil {
+					return nil, err
+				}
+			}
+			var constraint Constraint
+			switch {
+			case part.Wildcard.Quantifier != nil:
+				// With a quantifier, '+' and '*' only differ in the minimum left out, e.g. +{,3} is +{1,3}
+				minimum, maximum := part.Wildcard.Bounds()
+				constraint = &WildcardSingleConstraint{Min: minimum, Max: maximum, Limits: limits}
+			case part.Wildcard.Symbol == "+":
+				constraint = &WildcardSingleConstraint{Min: 1, Max: 1, Limits: limits}
+			default:
+				constraint = &WildcardMultiConstraint{Limits: limits}
+			}
+			capture := part.Wildcard.Capture
+			if exclusion := part.Wildcard.Exclusion; exclusion != nil {
+				negated, err := compileNegation(exclusion, options)
+				if err != nil {
+					return nil, err
+				}
+				constraint = &NegatedConstraint{Constraint: constraint, Negated: negated}
+				if capture == nil {
+					capture = exclusion.Capture
+				}
+			}
+			constraints = append(constraints, withCapture(constraint, capture))
+
+		case part.Group != nil:
+			group, err := compileGroup(part.Group, options)
+			if err != nil {
+				return nil, err
 			}
-			constraints = append(constraints, &VariableConstraint{VariableName: part.Var.Name, Modifiers: modifiers})
+			constraints = append(constraints, withCapture(group, part.Group.Capture))
 
-		case part.Wildcard != nil:
-			if part.Wildcard.Symbol == "+" {
-				if part.Wildcard.Quantifier != nil {
-					//TODO: Do we need checks for individual fields ?
-					min := part.Wildcard.Quantifier.Min
-					max := part.Wildcard.Quantifier.Max
-					constraints = append(constraints, &WildcardSingleConstraint{Min: min, Max: max})
-				} else {
-					//We handle behaviour as before
-					constraints = append(constraints, &WildcardSingleConstraint{Min: 1, Max: 1})
+		case part.Condition != nil:
+			conditional, err := compileCondition(part.Condition, options)
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, conditional)
+
+		case part.Negation != nil:
+			negated, err := compileNegation(part.Negation, options)
+			if err != nil {
+				return nil, err
+			}
+			constraint := &NegatedConstraint{Constraint: &WildcardSingleConstraint{Min: 1, Max: 1}, Negated: negated}
+			constraints = append(constraints, withCapture(constraint, part.Negation.Capture))
+
+		case len(part.Pieces) == 1:
+			constraint, err := compilePiece(part.Pieces[0], false, options)
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, constraint)
+
+		default:
+			pieces := make([]Constraint, 0, len(part.Pieces))
+			for _, piece := range part.Pieces {
+				constraint, err := compilePiece(piece, true, options)
+				if err != nil {
+					return nil, err
 				}
-			} else if part.Wildcard.Symbol == "*" {
-				constraints = append(constraints, &WildcardMultiConstraint{})
+				pieces = append(pieces, constraint)
 			}
+			constraints = append(constraints, &CompositeConstraint{Pieces: pieces})
+		}
+	}
+
+	return constraints, nil
+}
 
-		case part.VarSet != nil:
-			constraints = append(constraints, &VariableSetConstraint{VariableName: part.VarSet.Name})
+func compileGroup(group *parser.Group, options SchemaOptions) (*GroupConstraint, error) {
+	constraint := &GroupConstraint{
+		Optional:      group.Optional != "",
+		PreferOmitted: group.Optional == "??",
+	}
+	for _, branch := range group.Branches {
+		compiled, err := compileSchema(branch, options)
+		if err != nil {
+			return nil, err
+		}
+		constraint.Branches = append(constraint.Branches, compiled)
+		constraint.sources = append(constraint.sources, branch.Source())
+	}
+	return constraint, nil
+}
 
-		case part.Literal != nil:
-			constraints = append(constraints, &LiteralConstraint{
-				Literal: *part.Literal,
-			})
-		case part.Regex != nil:
-			constraints = append(constraints, &RegexConstraint{Pattern: *part.Regex})
+func compileCondition(condition *parser.Condition, options SchemaOptions) (*ConditionalConstraint, error) {
+	constraint := &ConditionalConstraint{Capture: condition.Capture, CaseInsensitive: options.CaseInsensitive}
+	if condition.Regex != nil {
+		pattern, err := regexp.Compile(*condition.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex '%s': %w", *condition.Regex, err)
 		}
+		constraint.Pattern = pattern
+	} else {
+		constraint.Value = *condition.Literal
 	}
 
-	return constraints
+	then, err := compileSchema(condition.Then, options)
+	if err != nil {
+		return nil, err
+	}
+	constraint.Then = then
+	constraint.sources[0] = condition.Then.Source()
+	if condition.Else != nil {
+		otherwise, err := compileSchema(condition.Else, options)
+		if err != nil {
+			return nil, err
+		}
+		constraint.Else = otherwise
+		constraint.sources[1] = condition.Else.Source()
+	}
+	return constraint, nil
+}
+
+// compileNegation compiles what a negation must not match. Captures inside of it are never recorded, as it only
+// matches when they didn't.
+func compileNegation(negation *parser.Negation, options SchemaOptions) (Constraint, error) {
+	if negation.Group != nil {
+		return compileGroup(negation.Group, options)
+	}
+	piece := &parser.Piece{Var: negation.Var, VarSet: negation.VarSet, Backref: negation.Backref, Literal: negation.Literal, Regex: negation.Regex, Type: negation.Type, Flags: negation.Flags}
+	return compilePiece(piece, false, options)
+}
+
+// compileModifiers compiles the modifier calls of a variable or variable set, checking the calls of the registered
+// modifiers against their signatures.
+func compileModifiers(modifiers []*parser.Modifier, set bool, options SchemaOptions) ([]VariableModifier, error) {
+	compiled := make([]VariableModifier, 0, len(modifiers))
+	for _, modifier := range modifiers {
+		call := VariableModifier{FuncName: modifier.Func, Args: compileArgs(modifier.Args)}
+		if spec, found := options.modifiers().Lookup(modifier.Func); found {
+			if err := spec.checkCall(call.Args, set); err != nil {
+				return nil, err
+			}
+			spec.compileRegexes(call.Args)
+			call.spec = spec
+		}
+		compiled = append(compiled, call)
+	}
+	return compiled, nil
+}
+
+func compilePiece(piece *parser.Piece, inComposite bool, options SchemaOptions) (Constraint, error) {
+	caseInsensitive := options.CaseInsensitive
+	switch piece.Flags {
+	case "":
+	case "i":
+		caseInsensitive = true
+	default:
+		return nil, fmt.Errorf("unknown flag '%s'", piece.Flags)
+	}
+
+	var constraint Constraint
+	switch {
+	case piece.Var != nil:
+		modifiers, err := compileModifiers(piece.Var.Modifiers, false, options)
+		if err != nil {
+			return nil, err
+		}
+		constraint = &VariableConstraint{VariableName: piece.Var.Name, Modifiers: modifiers, CaseInsensitive: caseInsensitive}
+
+	case piece.VarSet != nil:
+		// Members are matched ignoring case as a whole
+		memberOptions := options
+		memberOptions.CaseInsensitive = caseInsensitive
+		modifiers, err := compileModifiers(piece.VarSet.Modifiers, true, options)
+		if err != nil {
+			return nil, err
+		}
+		constraint = &VariableSetConstraint{VariableName: piece.VarSet.Name, Modifiers: modifiers, options: memberOptions}
+
+	case piece.Backref != nil:
+		constraint = &BackreferenceConstraint{Name: piece.Backref.Name, CaseInsensitive: caseInsensitive}
+
+	case piece.Literal != nil:
+		constraint = &LiteralConstraint{
+			Literal:         *piece.Literal,
+			CaseInsensitive: caseInsensitive,
+			expected:        []string{*piece.Literal},
+		}
+	case piece.Regex != nil:
+		pattern := *piece.Regex
+		if inComposite {
+			// Regexes inside a composite segment must cover exactly their own piece of the segment
+			pattern = "^(?:" + pattern + ")$"
+		}
+		if captureReference.MatchString(pattern) {
+			// The captured text is only known while matching
+			constraint = &RegexConstraint{Pattern: pattern}
+			break
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex '%s': %w", *piece.Regex, err)
+		}
+		constraint = &RegexConstraint{Pattern: pattern, compiled: compiled}
+
+	case piece.Type != nil:
+		typed, err := compileType(*piece.Type)
+		if err != nil {
+			return nil, err
+		}
+		constraint = typed
+	}
+	return withCapture(constraint, piece.Capture), nil
+}
+
+func withCapture(constraint Constraint, capture *parser.Capture) Constraint {
+	if capture == nil {
+		return constraint
+	}
+	return &CaptureConstraint{Name: capture.Name, Constraint: constraint}
 }
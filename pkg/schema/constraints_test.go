@@ -46,8 +46,7 @@ func (vs *testVariableStore) GetVariableSet(name string) ([]string, bool) {
 func (tc *constraintTestCase) test(t *testing.T) {
 	t.Run(fmt.Sprintf("%s", strings.Join(tc.path, ":")), func(t *testing.T) {
 		ctx := ValidationContext{
-			VariableStore:     &testVariableStore{t: t, testCase: tc},
-			VariableModifiers: getPredefinedModifiers(),
+			VariableStore: &testVariableStore{t: t, testCase: tc},
 		}
 		rest, err := tc.constraint.Consume(tc.path, &ctx)
 		if !tc.shouldFail {
@@ -68,7 +67,7 @@ func (tc *constraintTestCase) test(t *testing.T) {
 
 func TestLiteralConstraint(t *testing.T) {
 	c := func(value string) Constraint {
-		return &LiteralConstraint{value}
+		return &LiteralConstraint{Literal: value}
 	}
 
 	cases := []constraintTestCase{
@@ -117,9 +116,8 @@ func TestLiteralConstraint(t *testing.T) {
 
 func TestWildcardSingleConstraint(t *testing.T) {
 	c := func(minSegments int, maxSegments int) Constraint {
-		return &WildcardSingleConstraint{minSegments, maxSegments}
+		return &WildcardSingleConstraint{Min: minSegments, Max: maxSegments}
 	}
-	// TODO: add different min/max test cases when the behaviour is finalized
 	cases := []constraintTestCase{
 		{
 			constraint:   c(1, 1),
@@ -152,12 +150,49 @@ func TestWildcardSingleConstraint(t *testing.T) {
 			expectedRest: []string{"b", "c", "d"},
 		},
 
+		{
+			constraint:   c(0, 2),
+			path:         []string{"a", "b", "c"},
+			shouldFail:   false,
+			expectedRest: []string{"c"},
+		},
+		{
+			constraint:   c(0, 2),
+			path:         []string{},
+			shouldFail:   false,
+			expectedRest: []string{},
+		},
+		{
+			constraint:   c(2, 3),
+			path:         []string{"a", "b"},
+			shouldFail:   false,
+			expectedRest: []string{},
+		},
+		{
+			constraint:   c(2, -1),
+			path:         []string{"a", "b", "c", "d"},
+			shouldFail:   false,
+			expectedRest: []string{},
+		},
+		{
+			constraint:   c(2, -1),
+			path:         []string{"a"},
+			shouldFail:   true,
+			expectedRest: nil,
+		},
+
 		{
 			constraint:   c(1, 1),
 			path:         []string{},
 			shouldFail:   true,
 			expectedRest: nil,
 		},
+		{
+			constraint:   c(2, 3),
+			path:         []string{"a"},
+			shouldFail:   true,
+			expectedRest: nil,
+		},
 	}
 	for _, testCase := range cases {
 		testCase.test(t)
@@ -298,7 +333,7 @@ func TestVariableConstraint(t *testing.T) {
 	t.Run("WithSingleModifier", func(t *testing.T) {
 		c := &VariableConstraint{VariableName: "varName", Modifiers: []VariableModifier{{
 			FuncName: "strip_last_prefix",
-			Args:     []string{"prefix-"},
+			Args:     []ModifierArg{StringArg("prefix-")},
 		}}}
 		cases := []constraintTestCase{
 			{
@@ -396,11 +431,11 @@ func TestVariableConstraint(t *testing.T) {
 		c := &VariableConstraint{VariableName: "varName", Modifiers: []VariableModifier{
 			{
 				FuncName: "strip_last_prefix",
-				Args:     []string{"first-"},
+				Args:     []ModifierArg{StringArg("first-")},
 			},
 			{
 				FuncName: "strip_last_prefix",
-				Args:     []string{"second-"},
+				Args:     []ModifierArg{StringArg("second-")},
 			},
 		}}
 		cases := []constraintTestCase{
@@ -0,0 +1,189 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diagnose renders an error returned by CreateSchema, Validate or Match for humans. The schema or input is printed
+// with a caret under the offending token or segment, followed by a short explanation and, for variable set and group
+// misses, a suggestion of the closest member. Other errors are returned as they are.
+func Diagnose(err error) string {
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
+		return diagnoseSchemaError(schemaErr)
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return diagnoseValidationError(validationErr)
+	}
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func diagnoseSchemaError(err *SchemaError) string {
+	lines := strings.Split(err.Schema, "\n")
+	line := ""
+	if err.Line >= 1 && err.Line <= len(lines) {
+		line = lines[err.Line-1]
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("error: %s\n", err.Message))
+	writeCaret(&builder, line, max(err.Column-1, 0), err.Length)
+	builder.WriteString("  the schema is invalid at this position\n")
+	return builder.String()
+}
+
+func diagnoseValidationError(err *ValidationError) string {
+	// The offset points at the failing segment, or the end of the input when it ran out.
+	column := utf8.RuneCountInString(err.Input[:min(err.offset, len(err.Input))])
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("error: %v\n", errors.Unwrap(err)))
+	// The caret spans the segment as it is in the input, which normalisation may have changed
+	length := utf8.RuneCountInString(err.raw)
+	if err.raw == "" {
+		length = utf8.RuneCountInString(err.Actual)
+	}
+	writeCaret(&builder, err.Input, column, length)
+	builder.WriteString("  ")
+	builder.WriteString(explain(err))
+	builder.WriteString("\n")
+	if err.Reason == ReasonSetMiss || err.Reason == ReasonGroupMiss {
+		if suggestion, found := closestMember(err.Actual, err.Expected); found {
+			builder.WriteString(fmt.Sprintf("  did you mean '%s'?\n", suggestion))
+		}
+	}
+	return builder.String()
+}
+
+func writeCaret(builder *strings.Builder, line string, column int, length int) {
+	builder.WriteString("  ")
+	builder.WriteString(line)
+	builder.WriteString("\n  ")
+	builder.WriteString(strings.Repeat(" ", column))
+	builder.WriteString(strings.Repeat("^", max(length, 1)))
+	builder.WriteString("\n")
+}
+
+// explain describes in one sentence what the schema expected where the input was rejected.
+func explain(err *ValidationError) string {
+	expected := strings.Join(err.Expected, "', '")
+	switch err.Reason {
+	case ReasonLiteralMismatch:
+		return fmt.Sprintf("this segment must be exactly '%s'", expected)
+	case ReasonRegexMismatch:
+		return fmt.Sprintf("this segment must match the regular expression '%s'", expected)
+	case ReasonInvalidRegex:
+		return "the regular expression in the schema is invalid"
+	case ReasonVariableMismatch:
+		return fmt.Sprintf("this segment must be '%s', taken from variable '%s'", expected, err.Constraint.GetVariableName())
+	case ReasonMissingVariable:
+		return fmt.Sprintf("variable '%s' is not defined in the variable store", err.Constraint.GetVariableName())
+	case ReasonMissingVariableSet:
+		return fmt.Sprintf("variable set '%s' is not defined in the variable store", err.Constraint.GetVariableName())
+	case ReasonEmptyVariableSet:
+		return fmt.Sprintf("variable set '%s' has no members, so nothing can match it", err.Constraint.GetVariableName())
+	case ReasonInvalidSetMember:
+		return fmt.Sprintf("a member of variable set '%s' is not a valid schema", err.Constraint.GetVariableName())
+	case ReasonSetMiss:
+		return fmt.Sprintf("this segment must match one of the members of variable set '%s': '%s'", err.Constraint.GetVariableName(), expected)
+	case ReasonGroupMiss:
+		return fmt.Sprintf("this segment must match one of the branches of the group: '%s'", expected)
+	case ReasonExcluded:
+		return "this segment is explicitly excluded by the schema"
+	case ReasonMissingCapture:
+		return "the schema refers to a capture which has not matched anything before"
+	case ReasonBackreferenceMismatch:
+		return fmt.Sprintf("this segment must be '%s', as captured earlier", expected)
+	case ReasonMissingModifier:
+		return "the schema uses a modifier which is not registered"
+	case ReasonModifierFailed:
+		return "a modifier could not be applied to the variable value"
+	case ReasonTypeMismatch:
+		return fmt.Sprintf("this segment must be of the type %s", expected)
+	case ReasonOutOfRange:
+		return fmt.Sprintf("this number must be within the range of %s", expected)
+	case ReasonEmptySegment:
+		return "segments must not be empty"
+	case ReasonLeadingSeparator:
+		if len(err.Expected) > 0 {
+			return fmt.Sprintf("the input must start with the separator '%s'", expected)
+		}
+		return "the input must not start with a separator"
+	case ReasonTrailingSeparator:
+		if len(err.Expected) > 0 {
+			return fmt.Sprintf("the input must end with the separator '%s'", expected)
+		}
+		return "the input must not end with a separator"
+	case ReasonInvalidEncoding:
+		return "this segment contains a '%' which does not start a valid escape"
+	case ReasonNormalisationFailed:
+		return "this segment could not be normalised before matching"
+	case ReasonSegmentLength:
+		return fmt.Sprintf("this segment must be %s characters long", expected)
+	case ReasonCharsetMismatch:
+		return fmt.Sprintf("this segment may only contain the characters %s", expected)
+	case ReasonPathTooLong:
+		return fmt.Sprintf("the input must be at most %s characters long", expected)
+	case ReasonPathTooDeep:
+		return fmt.Sprintf("the input must have at most %s segments", expected)
+	case ReasonCompositeMismatch:
+		return "this segment does not have the form required by the schema"
+	case ReasonTooShort:
+		if len(err.Expected) > 0 {
+			return fmt.Sprintf("the input ended, but the schema expects '%s' here", expected)
+		}
+		return "the input ended before the schema was complete"
+	case ReasonTrailingSegments:
+		return "the schema was complete, but the input continues"
+	case ReasonSeparatorMismatch:
+		return fmt.Sprintf("this segment must follow the separator '%s'", strings.Join(err.Expected, "' or '"))
+	case ReasonTooComplex:
+		return "the schema backtracks too much on this input, see SchemaOptions.MaxSteps"
+	}
+	return string(err.Reason)
+}
+
+// closestMember suggests the member with the smallest edit distance to the value, as long as that distance is small
+// compared to the length of the member.
+func closestMember(value string, members []string) (string, bool) {
+	best, bestDistance := "", -1
+	for _, member := range members {
+		distance := editDistance(value, member)
+		if distance > max(utf8.RuneCountInString(member)/3, 1) {
+			continue
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = member, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+// editDistance computes the Levenshtein distance between two strings.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
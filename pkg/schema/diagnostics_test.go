@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseValidationError(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"technologies": {"mssql", "postgres", "kafka"},
+		},
+	}
+	cases := []struct {
+		name     string
+		schema   string
+		input    string
+		expected string
+	}{
+		{
+			name:   "SetMissWithSuggestion",
+			schema: `secret/$[technologies]/+`,
+			input:  "secret/postgress/admin",
+			expected: "error: 'postgress' is not a member of variable set 'technologies'\n" +
+				"  secret/postgress/admin\n" +
+				"         ^^^^^^^^^\n" +
+				"  this segment must match one of the members of variable set 'technologies': 'mssql', 'postgres', 'kafka'\n" +
+				"  did you mean 'postgres'?\n",
+		},
+		{
+			// The wildcard may take every segment, which leaves nothing for the set
+			name:   "SetMissAfterWildcard",
+			schema: `secret/*/$[technologies]`,
+			input:  "secret/a/b/mssqll",
+			expected: "error: 'mssqll' is not a member of variable set 'technologies'\n" +
+				"  secret/a/b/mssqll\n" +
+				"             ^^^^^^\n" +
+				"  this segment must match one of the members of variable set 'technologies': 'mssql', 'postgres', 'kafka'\n" +
+				"  did you mean 'mssql'?\n",
+		},
+		{
+			name:   "SetMissWithoutSuggestion",
+			schema: `secret/$[technologies]`,
+			input:  "secret/redis",
+			expected: "error: 'redis' is not a member of variable set 'technologies'\n" +
+				"  secret/redis\n" +
+				"         ^^^^^\n" +
+				"  this segment must match one of the members of variable set 'technologies': 'mssql', 'postgres', 'kafka'\n",
+		},
+		{
+			name:   "TooShort",
+			schema: `secret/deployment`,
+			input:  "secret",
+			expected: "error: empty path\n" +
+				"  secret\n" +
+				"        ^\n" +
+				"  the input ended, but the schema expects 'deployment' here\n",
+		},
+		{
+			name:   "TrailingSegments",
+			schema: `secret/+`,
+			input:  "secret/a/b",
+			expected: "error: input did not fully consume all segments, remaining: [b]\n" +
+				"  secret/a/b\n" +
+				"           ^\n" +
+				"  the schema was complete, but the input continues\n",
+		},
+		{
+			name:   "GroupMiss",
+			schema: `(apps|infra/+)/$[technologies]`,
+			input:  "app/postgres",
+			expected: "error: 'app' does not match any branch of the group\n" +
+				"  app/postgres\n" +
+				"  ^^^\n" +
+				"  this segment must match one of the branches of the group: 'apps', 'infra/+'\n" +
+				"  did you mean 'apps'?\n",
+		},
+		{
+			name:   "Excluded",
+			schema: `secret/+!$[technologies]`,
+			input:  "secret/kafka",
+			expected: "error: 'kafka' is excluded by VariableSetConstraint(technologies)\n" +
+				"  secret/kafka\n" +
+				"         ^^^^^\n" +
+				"  this segment is explicitly excluded by the schema\n",
+		},
+		{
+			name:   "BackreferenceMismatch",
+			schema: `teams/+:team/owners/\team`,
+			input:  "teams/core/owners/infra",
+			expected: "error: expected 'core' as captured by 'team', got 'infra'\n" +
+				"  teams/core/owners/infra\n" +
+				"                    ^^^^^\n" +
+				"  this segment must be 'core', as captured earlier\n",
+		},
+		{
+			name:   "SeparatorMismatch",
+			schema: `$[technologies]/+:+`,
+			input:  "postgres/a/b",
+			expected: "error: expected separator ':' in front of 'b', got '/'\n" +
+				"  postgres/a/b\n" +
+				"             ^\n" +
+				"  this segment must follow the separator ':'\n",
+		},
+		{
+			name:   "CharsetMismatch",
+			schema: `secret/+<len 3..40, charset [a-z0-9-]>`,
+			input:  "secret/Helm",
+			expected: "error: 'Helm' contains 'H', which is not in [a-z0-9-]\n" +
+				"  secret/Helm\n" +
+				"         ^^^^\n" +
+				"  this segment may only contain the characters [a-z0-9-]\n",
+		},
+		{
+			name:   "EmptySegment",
+			schema: `secret/+/+`,
+			input:  "secret//admin",
+			expected: "error: empty segment\n" +
+				"  secret//admin\n" +
+				"         ^\n" +
+				"  segments must not be empty\n",
+		},
+		{
+			name:   "LeadingSeparator",
+			schema: `secret/$[technologies]`,
+			input:  "/secret/redis",
+			expected: "error: input must not start with the separator '/'\n" +
+				"  /secret/redis\n" +
+				"  ^\n" +
+				"  the input must not start with a separator\n",
+		},
+		{
+			name:   "TrailingSeparator",
+			schema: `secret/$[technologies]`,
+			input:  "secret/kafka/",
+			expected: "error: input must not end with the separator '/'\n" +
+				"  secret/kafka/\n" +
+				"              ^\n" +
+				"  the input must not end with a separator\n",
+		},
+		{
+			name:   "OutOfRange",
+			schema: `releases/<int:1..99>`,
+			input:  "releases/120",
+			expected: "error: 120 is greater than the maximum 99\n" +
+				"  releases/120\n" +
+				"           ^^^\n" +
+				"  this number must be within the range of <int:1..99>\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiled, err := CreateSchema(tc.schema)
+			if err != nil {
+				t.Fatalf("Cannot create schema %s: %v", tc.schema, err)
+			}
+			err = compiled.Validate(tc.input, &ValidationContext{VariableStore: store})
+			if err == nil {
+				t.Fatalf("Validation of %s against %s succeeded when it was expected to fail", tc.input, tc.schema)
+			}
+			diagnostic := Diagnose(err)
+			if diagnostic != tc.expected {
+				t.Fatalf("Expected diagnostic:\n%s\ngot:\n%s", tc.expected, diagnostic)
+			}
+		})
+	}
+}
+
+func TestDiagnoseSchemaError(t *testing.T) {
+	_, err := CreateSchema(`$gitlab_path/+{1,2/$[technologies]`)
+	if err == nil {
+		t.Fatalf("Schema creation succeeded when it was expected to fail")
+	}
+	diagnostic := Diagnose(err)
+	lines := strings.Split(diagnostic, "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "error: unexpected token \"/\"") {
+		t.Fatalf("Unexpected diagnostic:\n%s", diagnostic)
+	}
+	if lines[1] != "  $gitlab_path/+{1,2/$[technologies]" || lines[2] != "                    ^" {
+		t.Fatalf("Caret is not under the offending token:\n%s", diagnostic)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"postgres", "postgres", 0},
+		{"postgress", "postgres", 1},
+		{"kafak", "kafka", 2},
+		{"mssql", "mysql", 1},
+	}
+	for _, tc := range cases {
+		if distance := editDistance(tc.a, tc.b); distance != tc.distance {
+			t.Fatalf("Expected distance between \"%s\" and \"%s\" to be %d, got %d", tc.a, tc.b, tc.distance, distance)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2"
+)
+
+// SchemaError is returned by CreateSchema when the schema text cannot be parsed.
+type SchemaError struct {
+	Schema string
+	// Line and Column of the offending token, both starting at 1.
+	Line   int
+	Column int
+	// Length of the offending token, at least 1.
+	Length  int
+	Message string
+	err     error
+}
+
+func newSchemaError(schema string, err error) error {
+	var parseErr participle.Error
+	if !errors.As(err, &parseErr) {
+		return err
+	}
+	length := 1
+	var tokenErr *participle.UnexpectedTokenError
+	if errors.As(err, &tokenErr) && utf8.RuneCountInString(tokenErr.Unexpected.Value) > 1 {
+		length = utf8.RuneCountInString(tokenErr.Unexpected.Value)
+	}
+	return &SchemaError{
+		Schema:  schema,
+		Line:    parseErr.Position().Line,
+		Column:  parseErr.Position().Column,
+		Length:  length,
+		Message: parseErr.Message(),
+		err:     err,
+	}
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("invalid schema '%s' at %d:%d: %s", e.Schema, e.Line, e.Column, e.Message)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.err
+}
+
+// ValidationReason is a machine-readable code describing why an input was rejected.
+type ValidationReason string
+
+const (
+	ReasonLiteralMismatch       ValidationReason = "literal-mismatch"
+	ReasonRegexMismatch         ValidationReason = "regex-mismatch"
+	ReasonInvalidRegex          ValidationReason = "invalid-regex"
+	ReasonVariableMismatch      ValidationReason = "variable-mismatch"
+	ReasonMissingVariable       ValidationReason = "missing-variable"
+	ReasonMissingVariableSet    ValidationReason = "missing-variable-set"
+	ReasonEmptyVariableSet      ValidationReason = "empty-variable-set"
+	ReasonInvalidSetMember      ValidationReason = "invalid-set-member"
+	ReasonSetMiss               ValidationReason = "set-miss"
+	ReasonGroupMiss             ValidationReason = "group-miss"
+	ReasonExcluded              ValidationReason = "excluded"
+	ReasonMissingCapture        ValidationReason = "missing-capture"
+	ReasonBackreferenceMismatch ValidationReason = "backreference-mismatch"
+	ReasonMissingModifier       ValidationReason = "missing-modifier"
+	ReasonModifierFailed        ValidationReason = "modifier-failed"
+	ReasonCompositeMismatch     ValidationReason = "composite-mismatch"
+	ReasonTypeMismatch          ValidationReason = "type-mismatch"
+	ReasonOutOfRange            ValidationReason = "out-of-range"
+	ReasonEmptySegment          ValidationReason = "empty-segment"
+	ReasonLeadingSeparator      ValidationReason = "leading-separator"
+	ReasonTrailingSeparator     ValidationReason = "trailing-separator"
+	ReasonInvalidEncoding       ValidationReason = "invalid-encoding"
+	ReasonNormalisationFailed   ValidationReason = "normalisation-failed"
+	ReasonSegmentLength         ValidationReason = "segment-length"
+	ReasonCharsetMismatch       ValidationReason = "charset-mismatch"
+	ReasonPathTooLong           ValidationReason = "path-too-long"
+	ReasonPathTooDeep           ValidationReason = "path-too-deep"
+	ReasonTooShort              ValidationReason = "too-short"
+	ReasonTrailingSegments      ValidationReason = "trailing-segments"
+	ReasonSeparatorMismatch     ValidationReason = "separator-mismatch"
+	ReasonTooComplex            ValidationReason = "too-complex"
+)
+
+// ValidationError describes why an input was rejected by a schema. When matching backtracked over several
+// alternatives, it describes the failure that got furthest into the input.
+type ValidationError struct {
+	Input string
+	// Index of the failing segment. It equals the number of input segments when the input ended too early.
+	Index int
+	// Constraint that rejected the input, nil when the whole schema matched but left segments over.
+	Constraint Constraint
+	// Expected holds the value or values the constraint would have accepted, when they are known.
+	Expected []string
+	// Actual is the segment found at Index, empty when the input ended too early.
+	Actual string
+	Reason ValidationReason
+
+	// remaining is the number of segments left when the failure occurred. Constraints only see a suffix of the input,
+	// so the Index is filled in from it once the failure reaches the schema.
+	remaining int
+	// offset is the byte offset of the failing segment in the input, and raw the segment as it is in the input,
+	// which differs from Actual when the input was normalised.
+	offset int
+	raw    string
+	// progress is the number of constraints that matched before the failure, see matchState.stamp.
+	progress int
+	stamped  bool
+	// format and args give the message, which is only formatted when it's asked for. Most failures are those of
+	// alternatives the matching backtracks over, which are dropped unread. A mismatch has no format, its message
+	// names the Expected values and the Actual segment.
+	format   string
+	args     []any
+	mismatch bool
+}
+
+func (e *ValidationError) Error() string {
+	if e.Constraint == nil {
+		return fmt.Sprintf("input '%s' rejected at segment %d: %v", e.Input, e.Index, e.cause())
+	}
+	return fmt.Sprintf("input '%s' rejected at segment %d by constraint %s: %v", e.Input, e.Index, e.Constraint.String(), e.cause())
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.cause()
+}
+
+// cause returns the message of the failure as an error, wrapping the error it was caused by if there is one.
+func (e *ValidationError) cause() error {
+	if e.mismatch {
+		return fmt.Errorf("expected '%s', got '%s'", strings.Join(e.Expected, "' or '"), e.Actual)
+	}
+	return fmt.Errorf(e.format, e.args...)
+}
+
+func newValidationError(constraint Constraint, path []string, reason ValidationReason, expected []string, format string, args ...any) *ValidationError {
+	actual := ""
+	if len(path) > 0 {
+		actual = path[0]
+	}
+	return &ValidationError{
+		Constraint: constraint,
+		Expected:   expected,
+		Actual:     actual,
+		Reason:     reason,
+		remaining:  len(path),
+		format:     format,
+		args:       args,
+	}
+}
+
+// newMismatchError returns the failure of a segment which is not one of the expected values. It needs no arguments
+// to be formatted, which keeps the failures of alternatives cheap.
+func newMismatchError(constraint Constraint, path []string, reason ValidationReason, expected []string) *ValidationError {
+	err := newValidationError(constraint, path, reason, expected, "")
+	err.mismatch = true
+	return err
+}
+
+// pickError returns the more relevant of two failures from alternative matching attempts. That is the attempt
+// which matched more of the schema, or the one which got further into the input when both matched as much, unless
+// that one only ran out of input. An exclusion beats segments left over, as it rejected the match which covered
+// them. Errors which aren't a ValidationError are preferred over nothing but lose to any ValidationError.
+func pickError(best error, err error) error {
+	if best == nil {
+		return err
+	}
+	if err == nil {
+		return best
+	}
+	// Failures are never wrapped while matching, and a type assertion doesn't allocate unlike errors.As
+	bestErr, ok := best.(*ValidationError)
+	if !ok {
+		return err
+	}
+	newErr, ok := err.(*ValidationError)
+	if !ok {
+		return best
+	}
+	// A shorter match leaving segments over gets further into the schema than the exclusion of the longer one
+	switch {
+	case newErr.Reason == ReasonExcluded && bestErr.Reason == ReasonTrailingSegments:
+		return err
+	case bestErr.Reason == ReasonExcluded && newErr.Reason == ReasonTrailingSegments:
+		return best
+	}
+	if newErr.progress != bestErr.progress {
+		if newErr.progress > bestErr.progress {
+			return err
+		}
+		return best
+	}
+	// A wildcard taking every segment leaves nothing for the constraint after it, which says less than the mismatch
+	// of a segment when the wildcard took fewer
+	if newEnded, bestEnded := newErr.endOfInput(), bestErr.endOfInput(); newEnded != bestEnded {
+		if bestEnded {
+			return err
+		}
+		return best
+	}
+	if newErr.remaining < bestErr.remaining {
+		return err
+	}
+	return best
+}
+
+// endOfInput reports whether the failure is that of a constraint which found no segment left.
+func (e *ValidationError) endOfInput() bool {
+	return e.remaining == 0 && e.Reason == ReasonTooShort
+}
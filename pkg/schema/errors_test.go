@@ -0,0 +1,169 @@
+package schema
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type validationErrorTestCase struct {
+	name     string
+	schema   string
+	input    string
+	index    int
+	reason   ValidationReason
+	expected []string
+	actual   string
+}
+
+func (tc *validationErrorTestCase) test(store VariableStore, t *testing.T) {
+	t.Run(tc.name, func(t *testing.T) {
+		compiled, err := CreateSchema(tc.schema)
+		if err != nil {
+			t.Fatalf("Cannot create schema %s: %v", tc.schema, err)
+		}
+		err = compiled.Validate(tc.input, &ValidationContext{VariableStore: store})
+		if err == nil {
+			t.Fatalf("Validation of %s against %s succeeded when it was expected to fail", tc.input, tc.schema)
+		}
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("Expected a ValidationError, got %T: %v", err, err)
+		}
+		if validationErr.Input != tc.input {
+			t.Fatalf("Expected input \"%s\", got \"%s\"", tc.input, validationErr.Input)
+		}
+		if validationErr.Reason != tc.reason {
+			t.Fatalf("Expected reason %s, got %s: %v", tc.reason, validationErr.Reason, err)
+		}
+		if validationErr.Index != tc.index {
+			t.Fatalf("Expected failure at segment %d, got %d: %v", tc.index, validationErr.Index, err)
+		}
+		if !slices.Equal(validationErr.Expected, tc.expected) {
+			t.Fatalf("Expected %v to be expected, got %v", tc.expected, validationErr.Expected)
+		}
+		if validationErr.Actual != tc.actual {
+			t.Fatalf("Expected actual value \"%s\", got \"%s\"", tc.actual, validationErr.Actual)
+		}
+	})
+}
+
+func TestValidationError(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"mssql", "postgres"},
+			"broken":       {"a//b"},
+		},
+	}
+	cases := []validationErrorTestCase{
+		{
+			name:     "LiteralMismatch",
+			schema:   `secret/deployment/+`,
+			input:    "secret/deploy/x",
+			index:    1,
+			reason:   ReasonLiteralMismatch,
+			expected: []string{"deployment"},
+			actual:   "deploy",
+		},
+		{
+			name:     "SetMiss",
+			schema:   `secret/$[technologies]`,
+			input:    "secret/kafka",
+			index:    1,
+			reason:   ReasonSetMiss,
+			expected: []string{"mssql", "postgres"},
+			actual:   "kafka",
+		},
+		{
+			name:     "VariableMismatchPointsAtSegment",
+			schema:   `$gitlab_path.strip_last_prefix("helm-")/+`,
+			input:    "group1/project2/x",
+			index:    1,
+			reason:   ReasonVariableMismatch,
+			expected: []string{"project1"},
+			actual:   "project2",
+		},
+		{
+			name:     "VariableTooShort",
+			schema:   `$gitlab_path`,
+			input:    "group1",
+			index:    1,
+			reason:   ReasonTooShort,
+			expected: []string{"helm-project1"},
+		},
+		{
+			name:   "TooShort",
+			schema: `a/b/+`,
+			input:  "a/b",
+			index:  2,
+			reason: ReasonTooShort,
+		},
+		{
+			name:   "TrailingSegments",
+			schema: `a/+`,
+			input:  "a/b/c/d",
+			index:  2,
+			reason: ReasonTrailingSegments,
+			actual: "c",
+		},
+		{
+			name:   "MissingVariable",
+			schema: `$unknown`,
+			input:  "a",
+			index:  0,
+			reason: ReasonMissingVariable,
+			actual: "a",
+		},
+		{
+			name:   "MissingVariableInComposite",
+			schema: `apps/app-$env-db`,
+			input:  "apps/app-prod-db",
+			index:  1,
+			reason: ReasonMissingVariable,
+			actual: "app-prod-db",
+		},
+		{
+			name:   "InvalidSetMember",
+			schema: `$[broken]`,
+			input:  "a/b",
+			index:  0,
+			reason: ReasonInvalidSetMember,
+			actual: "a",
+		},
+		{
+			name:     "FurthestFailureAfterBacktracking",
+			schema:   `*/postgres/admin`,
+			input:    "a/postgres/reader",
+			index:    2,
+			reason:   ReasonLiteralMismatch,
+			expected: []string{"admin"},
+			actual:   "reader",
+		},
+		{
+			// The wildcard taking every segment leaves nothing for the literal, which is not the failure to report
+			name:     "MismatchOverEndOfInput",
+			schema:   `secret/*/postgres`,
+			input:    "secret/a/b/mysql",
+			index:    3,
+			reason:   ReasonLiteralMismatch,
+			expected: []string{"postgres"},
+			actual:   "mysql",
+		},
+		{
+			// Matching fewer segments with the wildcard leaves segments over, which explains less than the exclusion
+			name:   "ExclusionOverShorterMatch",
+			schema: `a/*!(admin/root)`,
+			input:  "a/admin/root",
+			index:  1,
+			reason: ReasonExcluded,
+			actual: "admin",
+		},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
@@ -0,0 +1,334 @@
+package schema
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// EmptySegmentPolicy decides what happens to empty segments of the input, e.g. the one in a//b.
+type EmptySegmentPolicy int
+
+const (
+	// RejectEmptySegments fails the validation at the first empty segment.
+	RejectEmptySegments EmptySegmentPolicy = iota
+	// CollapseEmptySegments drops empty segments, so a//b is validated as a/b.
+	CollapseEmptySegments
+	// AllowEmptySegments keeps empty segments, which are then matched like any other segment, e.g. by a wildcard.
+	AllowEmptySegments
+)
+
+// SeparatorPolicy decides whether the input may start or end with the separator.
+type SeparatorPolicy int
+
+const (
+	// ForbidSeparator fails the validation when the separator is there.
+	ForbidSeparator SeparatorPolicy = iota
+	// RequireSeparator fails the validation when the separator is missing, and removes it otherwise.
+	RequireSeparator
+	// IgnoreSeparator removes the separator, repeated any number of times, when it's there.
+	IgnoreSeparator
+)
+
+// ValidationOptions decide how the input is normalised before it's matched. The zero value is strict: the input
+// may neither start nor end with a separator, nor contain empty segments, and segments are matched as they are.
+type ValidationOptions struct {
+	EmptySegments     EmptySegmentPolicy
+	LeadingSeparator  SeparatorPolicy
+	TrailingSeparator SeparatorPolicy
+	// PercentDecode decodes every segment as a URL path segment, e.g. helm%20chart to helm chart. Separators are
+	// found before decoding, so an encoded separator stays part of its segment.
+	PercentDecode bool
+	// NormaliseNFC brings every segment into Unicode normalisation form C, after percent-decoding, so that
+	// precomposed and decomposed characters compare equal.
+	NormaliseNFC bool
+	// Pipeline holds further steps run in order on the segments after the options above, e.g. to strip the mount of
+	// a Vault path before it's matched. MatchResult reports the input as it was and as the steps left it.
+	Pipeline []InputStep
+}
+
+// InputStepFunction decides on the segment at the index of the input, given all segments as the previous steps left
+// them. It returns the segment, possibly rewritten, and whether to keep it.
+type InputStepFunction func(segments []string, index int) (string, bool, error)
+
+// InputStep is a step of the input normalisation pipeline, see ValidationOptions.Pipeline. Steps can rewrite and
+// remove segments but not add any, so every segment still points to its position in the raw input.
+type InputStep struct {
+	// Name is reported in MatchResult.Normalisations when the step changed the input.
+	Name     Normalisation
+	Function InputStepFunction
+}
+
+// LowerCaseInput converts every segment to lower case.
+func LowerCaseInput() InputStep {
+	return InputStep{Name: NormalisedLowerCase, Function: func(segments []string, index int) (string, bool, error) {
+		return strings.ToLower(segments[index]), true, nil
+	}}
+}
+
+// StripMountPrefix removes the leading segments of the input when they are the mount, e.g. secret or kv/team1 with
+// segments separated by '/'. Inputs which don't start with the mount are kept as they are.
+func StripMountPrefix(mount string) InputStep {
+	prefix := strings.Split(mount, "/")
+	return InputStep{Name: NormalisedMountPrefix, Function: func(segments []string, index int) (string, bool, error) {
+		mounted := len(segments) >= len(prefix) && slices.Equal(segments[:len(prefix)], prefix)
+		return segments[index], !mounted || index >= len(prefix), nil
+	}}
+}
+
+// StripKVv2Segment removes the data or metadata segment a Vault KV version 2 path starts with once its mount is
+// stripped, e.g. data/team1/db to team1/db.
+func StripKVv2Segment() InputStep {
+	return InputStep{Name: NormalisedKVv2, Function: func(segments []string, index int) (string, bool, error) {
+		kv := len(segments) > 1 && (segments[0] == "data" || segments[0] == "metadata")
+		return segments[index], !kv || index > 0, nil
+	}}
+}
+
+// PercentDecodeInput decodes every segment like ValidationOptions.PercentDecode, at its position in the pipeline.
+func PercentDecodeInput() InputStep {
+	return InputStep{Name: NormalisedPercentDecoding, Function: func(segments []string, index int) (string, bool, error) {
+		decoded, err := url.PathUnescape(segments[index])
+		return decoded, true, err
+	}}
+}
+
+// apply runs the step on every segment, returning whether it changed any. All decisions are made on the segments as
+// the previous step left them.
+func (s InputStep) apply(input string, p *splitPath) (bool, error) {
+	segments := slices.Clone(p.segments)
+	changed := false
+	for i, j := 0, 0; i < len(segments); i++ {
+		segment, keep, err := s.Function(segments, i)
+		if err != nil {
+			return false, inputError(input, p, j, p.offsets[j], ReasonNormalisationFailed, nil, "normalisation '%s' failed on segment '%s': %w", s.Name, segments[i], err)
+		}
+		if !keep {
+			p.drop(j)
+			changed = true
+			continue
+		}
+		if segment != segments[i] {
+			p.segments[j] = segment
+			changed = true
+		}
+		j++
+	}
+	return changed, nil
+}
+
+// Normalisation names a change made to the input before it was matched, see MatchResult.Normalisations.
+type Normalisation string
+
+const (
+	NormalisedLeadingSeparator  Normalisation = "leading-separator"
+	NormalisedTrailingSeparator Normalisation = "trailing-separator"
+	NormalisedEmptySegments     Normalisation = "empty-segments"
+	NormalisedPercentDecoding   Normalisation = "percent-decoding"
+	NormalisedNFC               Normalisation = "nfc"
+	NormalisedLowerCase         Normalisation = "lower-case"
+	NormalisedMountPrefix       Normalisation = "mount-prefix"
+	NormalisedKVv2              Normalisation = "kv-v2"
+)
+
+// splitPath is an input split into segments. Besides the segments it holds the separator found in front of each
+// segment, which is empty for the first one, and the byte offset of each segment in the input followed by the
+// offset where the last one ends. The delimiters are nil when there is only one separator, as they would all be
+// the same. Segments may be normalised, so ends holds the byte offset where each one ends in the raw input.
+type splitPath struct {
+	segments   []string
+	delimiters []string
+	offsets    []int
+	ends       []int
+}
+
+// splitInput splits the input at every occurrence of one of the separators.
+func splitInput(input string, separators []string) splitPath {
+	if len(separators) == 1 {
+		separator := separators[0]
+		segments := strings.Split(input, separator)
+		offsets := make([]int, 0, len(segments)+1)
+		ends := make([]int, 0, len(segments))
+		offset := 0
+		for _, segment := range segments {
+			offsets = append(offsets, offset)
+			ends = append(ends, offset+len(segment))
+			offset += len(segment) + len(separator)
+		}
+		return splitPath{segments: segments, offsets: append(offsets, len(input)), ends: ends}
+	}
+
+	split := splitPath{delimiters: []string{""}, offsets: []int{0}}
+	segmentStart := 0
+	for i := 0; i < len(input); {
+		found := ""
+		for _, separator := range separators {
+			if strings.HasPrefix(input[i:], separator) {
+				found = separator
+				break
+			}
+		}
+		if found == "" {
+			i++
+			continue
+		}
+		split.segments = append(split.segments, input[segmentStart:i])
+		split.ends = append(split.ends, i)
+		split.delimiters = append(split.delimiters, found)
+		i += len(found)
+		segmentStart = i
+		split.offsets = append(split.offsets, segmentStart)
+	}
+	split.segments = append(split.segments, input[segmentStart:])
+	split.ends = append(split.ends, len(input))
+	split.offsets = append(split.offsets, len(input))
+	return split
+}
+
+// drop removes the segment at the index. The first remaining segment has no delimiter in front of it, and the end
+// of the input stays where the last segment ended.
+func (p *splitPath) drop(index int) {
+	last := index == len(p.segments)-1
+	p.segments = slices.Delete(p.segments, index, index+1)
+	p.ends = slices.Delete(p.ends, index, index+1)
+	if p.delimiters != nil {
+		delimiter := index
+		if index == 0 && len(p.delimiters) > 1 {
+			delimiter = 1
+		}
+		p.delimiters = slices.Delete(p.delimiters, delimiter, delimiter+1)
+	}
+	if last {
+		p.offsets = slices.Delete(p.offsets, index+1, index+2)
+	} else {
+		p.offsets = slices.Delete(p.offsets, index, index+1)
+	}
+}
+
+// raw returns the segment at the index as it is in the input, before it was normalised.
+func (p *splitPath) raw(input string, index int) string {
+	if index >= len(p.segments) {
+		return ""
+	}
+	return input[p.offsets[index]:p.ends[index]]
+}
+
+// delimiter returns the separator in front of the segment at the index.
+func (p *splitPath) delimiter(index int, separator string) string {
+	if p.delimiters == nil {
+		return separator
+	}
+	return p.delimiters[index]
+}
+
+// normalise applies the options to the split input and returns the normalisations made, or the error for the first
+// part of the input the options reject. An input which is left empty has no segments.
+func (o ValidationOptions) normalise(input string, p *splitPath, separator string) ([]Normalisation, error) {
+	var applied []Normalisation
+	leading := func() bool { return len(p.segments) > 1 && p.segments[0] == "" }
+	trailing := func() bool { return len(p.segments) > 1 && p.segments[len(p.segments)-1] == "" }
+
+	switch o.LeadingSeparator {
+	case ForbidSeparator:
+		if leading() {
+			return nil, inputError(input, p, 0, 0, ReasonLeadingSeparator, nil, "input must not start with the separator '%s'", p.delimiter(1, separator))
+		}
+	case RequireSeparator:
+		if !leading() {
+			return nil, inputError(input, p, 0, 0, ReasonLeadingSeparator, []string{separator}, "input must start with the separator '%s'", separator)
+		}
+		p.drop(0)
+		applied = append(applied, NormalisedLeadingSeparator)
+	case IgnoreSeparator:
+		if leading() {
+			for leading() {
+				p.drop(0)
+			}
+			applied = append(applied, NormalisedLeadingSeparator)
+		}
+	}
+
+	last := len(p.segments) - 1
+	switch o.TrailingSeparator {
+	case ForbidSeparator:
+		if trailing() {
+			delimiter := p.delimiter(last, separator)
+			return nil, inputError(input, p, last, p.offsets[last]-len(delimiter), ReasonTrailingSeparator, nil, "input must not end with the separator '%s'", delimiter)
+		}
+	case RequireSeparator:
+		if !trailing() {
+			return nil, inputError(input, p, len(p.segments), len(input), ReasonTrailingSeparator, []string{separator}, "input must end with the separator '%s'", separator)
+		}
+		p.drop(last)
+		applied = append(applied, NormalisedTrailingSeparator)
+	case IgnoreSeparator:
+		if trailing() {
+			for trailing() {
+				p.drop(len(p.segments) - 1)
+			}
+			applied = append(applied, NormalisedTrailingSeparator)
+		}
+	}
+
+	if len(p.segments) == 1 && p.segments[0] == "" {
+		p.drop(0)
+		return applied, nil
+	}
+	for i := 0; i < len(p.segments) && o.EmptySegments != AllowEmptySegments; i++ {
+		if p.segments[i] != "" {
+			continue
+		}
+		if o.EmptySegments == RejectEmptySegments {
+			return nil, inputError(input, p, i, p.offsets[i], ReasonEmptySegment, nil, "empty segment")
+		}
+		p.drop(i)
+		i--
+		applied = appendNormalisation(applied, NormalisedEmptySegments)
+	}
+
+	for i, segment := range p.segments {
+		if o.PercentDecode && strings.Contains(segment, "%") {
+			decoded, err := url.PathUnescape(segment)
+			if err != nil {
+				return nil, inputError(input, p, i, p.offsets[i], ReasonInvalidEncoding, nil, "segment '%s' is not percent-encoded correctly", segment)
+			}
+			segment = decoded
+			applied = appendNormalisation(applied, NormalisedPercentDecoding)
+		}
+		if o.NormaliseNFC && !norm.NFC.IsNormalString(segment) {
+			segment = norm.NFC.String(segment)
+			applied = appendNormalisation(applied, NormalisedNFC)
+		}
+		p.segments[i] = segment
+	}
+
+	for _, step := range o.Pipeline {
+		changed, err := step.apply(input, p)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			applied = appendNormalisation(applied, step.Name)
+		}
+	}
+	return applied, nil
+}
+
+func appendNormalisation(applied []Normalisation, normalisation Normalisation) []Normalisation {
+	if slices.Contains(applied, normalisation) {
+		return applied
+	}
+	return append(applied, normalisation)
+}
+
+// inputError returns a failure of the input as a whole, which is found before any constraint is matched.
+func inputError(input string, p *splitPath, index int, offset int, reason ValidationReason, expected []string, format string, args ...any) *ValidationError {
+	err := newValidationError(nil, p.segments[index:], reason, expected, format, args...)
+	err.Input = input
+	err.Index = index
+	err.offset = offset
+	err.raw = p.raw(input, index)
+	return err
+}
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPipelineLengthLimit(t *testing.T) {
+	// Lower case Ⱥ takes one byte more than upper case Ⱥ
+	options := SchemaOptions{MaxLength: 3, Validation: ValidationOptions{Pipeline: []InputStep{LowerCaseInput()}}}
+	compiled, err := CreateSchemaWithOptions(`+/+`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	err = compiled.Validate("ȺȺȺȺ/a", &ValidationContext{})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Reason != ReasonPathTooLong || validationErr.Index != 0 {
+		t.Fatalf("Expected the input to be too long from the first segment, got %v", err)
+	}
+	if err := compiled.Validate("Ⱥ/a", &ValidationContext{}); err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+}
+
+func TestDiagnoseNormalisedInput(t *testing.T) {
+	options := SchemaOptions{Validation: ValidationOptions{Pipeline: []InputStep{StripMountPrefix("secret"), PercentDecodeInput()}}}
+	compiled, err := CreateSchemaWithOptions(`apps/db`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	err = compiled.Validate("secret/apps/db%20x", &ValidationContext{})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Actual != "db x" {
+		t.Fatalf("Expected the decoded segment to be rejected, got %v", err)
+	}
+	// The caret points at the segment as it was given, behind the stripped mount
+	expected := "  secret/apps/db%20x\n              ^^^^^^\n"
+	if diagnosis := Diagnose(err); !strings.Contains(diagnosis, expected) {
+		t.Fatalf("Expected the caret under db%%20x, got:\n%s", diagnosis)
+	}
+}
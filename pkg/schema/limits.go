@@ -0,0 +1,159 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SegmentLimits restrict every segment consumed by a wildcard, e.g. +<len 3..40, charset [a-z0-9-]>.
+type SegmentLimits struct {
+	// MinLength and MaxLength bound the number of characters of a segment. MaxLength is negative when there is no
+	// upper bound.
+	MinLength int
+	MaxLength int
+	// Charset is a regex character class every character of a segment has to be in, e.g. [a-z0-9-]. Empty allows
+	// any character.
+	Charset string
+
+	charset *regexp.Regexp
+}
+
+// segmentLimit matches one limit of a wildcard along with the comma separating it from the next one. A charset
+// may contain commas, but only escaped closing brackets.
+var segmentLimit = regexp.MustCompile(`^\s*(len|charset)\s+(\[(?:\\.|[^\]\\])*\]|[^,]*?)\s*(?:,|$)`)
+
+// parseSegmentLimits parses the limits of a wildcard without their brackets, e.g. len 3..40, charset [a-z0-9-].
+func parseSegmentLimits(spec string) (*SegmentLimits, error) {
+	limits := &SegmentLimits{MaxLength: -1}
+	rest := spec
+	for strings.TrimSpace(rest) != "" {
+		found := segmentLimit.FindStringSubmatch(rest)
+		if found == nil {
+			return nil, fmt.Errorf("invalid segment limit '%s', expected len or charset", strings.TrimSpace(rest))
+		}
+		rest = rest[len(found[0]):]
+		switch keyword, value := found[1], found[2]; keyword {
+		case "len":
+			minimum, maximum, err := parseRange(value)
+			if err != nil {
+				return nil, fmt.Errorf("segment limit len: %w", err)
+			}
+			if minimum != nil {
+				limits.MinLength = *minimum
+			}
+			if maximum != nil {
+				limits.MaxLength = *maximum
+			}
+			if limits.MinLength < 0 || limits.MaxLength == 0 {
+				return nil, fmt.Errorf("segment limit len %s allows no segment", value)
+			}
+		case "charset":
+			if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("segment limit charset expects a character class like [a-z0-9-], got '%s'", value)
+			}
+			charset, err := regexp.Compile("^" + value + "$")
+			if err != nil {
+				return nil, fmt.Errorf("segment limit charset '%s' is invalid: %w", value, err)
+			}
+			limits.Charset = value
+			limits.charset = charset
+		}
+	}
+	return limits, nil
+}
+
+// parseRange parses a range of integers like 1..99, either bound of which may be left out, or a single integer
+// standing for both bounds. The bounds left out are nil.
+func parseRange(text string) (*int, *int, error) {
+	lower, upper, isRange := strings.Cut(text, "..")
+	if !isRange {
+		upper = lower
+	}
+	bounds := make([]*int, 0, 2)
+	for _, bound := range []string{lower, upper} {
+		if bound == "" && isRange {
+			bounds = append(bounds, nil)
+			continue
+		}
+		value, err := strconv.Atoi(bound)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expected a range like 1..99, got '%s'", text)
+		}
+		bounds = append(bounds, &value)
+	}
+	if bounds[0] != nil && bounds[1] != nil && *bounds[0] > *bounds[1] {
+		return nil, nil, fmt.Errorf("minimum %d is greater than the maximum %d", *bounds[0], *bounds[1])
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// acceptedSegments returns the number of leading segments of the path, up to the given number, which the wildcard
+// may consume, along with the reason the next one is rejected, if there is one.
+func acceptedSegments(c Constraint, limits *SegmentLimits, path []string, upper int) (int, error) {
+	if limits == nil {
+		return upper, nil
+	}
+	for i, segment := range path[:upper] {
+		if err := limits.check(c, segment, path[i:]); err != nil {
+			return i, err
+		}
+	}
+	return upper, nil
+}
+
+// check returns why the first segment of the path is not accepted by the limits.
+func (l *SegmentLimits) check(c Constraint, segment string, path []string) error {
+	if length := utf8.RuneCountInString(segment); length < l.MinLength || (l.MaxLength >= 0 && length > l.MaxLength) {
+		return newValidationError(c, path, ReasonSegmentLength, []string{l.lengthRange()}, "'%s' is %d characters long, expected %s", segment, length, l.lengthRange())
+	}
+	if l.charset != nil {
+		for _, char := range segment {
+			if !l.charset.MatchString(string(char)) {
+				return newValidationError(c, path, ReasonCharsetMismatch, []string{l.Charset}, "'%s' contains '%c', which is not in %s", segment, char, l.Charset)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *SegmentLimits) lengthRange() string {
+	switch {
+	case l.MaxLength < 0:
+		return fmt.Sprintf("%d..", l.MinLength)
+	case l.MinLength == l.MaxLength:
+		return strconv.Itoa(l.MinLength)
+	default:
+		return fmt.Sprintf("%d..%d", l.MinLength, l.MaxLength)
+	}
+}
+
+func (l *SegmentLimits) String() string {
+	limits := make([]string, 0, 2)
+	if l.MinLength > 0 || l.MaxLength >= 0 {
+		limits = append(limits, "len "+l.lengthRange())
+	}
+	if l.Charset != "" {
+		limits = append(limits, "charset "+l.Charset)
+	}
+	return "<" + strings.Join(limits, ", ") + ">"
+}
+
+// checkPathLimits rejects an input which is longer or deeper than the schema options allow, pointing at the first
+// segment beyond the limit. The segments may have been normalised, so their length is measured in the raw input.
+func (o SchemaOptions) checkPathLimits(input string, p *splitPath) error {
+	segments := p.segments
+	if o.MaxLength > 0 && utf8.RuneCountInString(input) > o.MaxLength {
+		index := 0
+		for index < len(segments)-1 && utf8.RuneCountInString(input[:p.ends[index]]) <= o.MaxLength {
+			index++
+		}
+		return newValidationError(nil, segments[index:], ReasonPathTooLong, []string{strconv.Itoa(o.MaxLength)}, "input is %d characters long, the maximum is %d", utf8.RuneCountInString(input), o.MaxLength)
+	}
+	if o.MaxDepth > 0 && len(segments) > o.MaxDepth {
+		return newValidationError(nil, segments[o.MaxDepth:], ReasonPathTooDeep, []string{strconv.Itoa(o.MaxDepth)}, "input has %d segments, the maximum is %d", len(segments), o.MaxDepth)
+	}
+	return nil
+}
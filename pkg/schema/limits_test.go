@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseSegmentLimits(t *testing.T) {
+	cases := []struct {
+		spec     string
+		expected string
+		err      string
+	}{
+		{spec: "len 3..40, charset [a-z0-9-]", expected: "<len 3..40, charset [a-z0-9-]>"},
+		{spec: "charset [a-z], len 8", expected: "<len 8, charset [a-z]>"},
+		{spec: " len ..63 ", expected: "<len 0..63>"},
+		{spec: "len 1..", expected: "<len 1..>"},
+		// Commas and escaped brackets inside a charset don't end it
+		{spec: `charset [,\]], len 2`, expected: `<len 2, charset [,\]]>`},
+		{spec: "len 0", err: "allows no segment"},
+		{spec: "len x", err: "expected a range like 1..99"},
+		{spec: "len 3, size 4", err: "invalid segment limit 'size 4'"},
+		{spec: "charset [a-", err: "expects a character class"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			limits, err := parseSegmentLimits(tc.spec)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected an error containing \"%s\", got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Cannot parse limits %s: %v", tc.spec, err)
+			}
+			if limits.String() != tc.expected {
+				t.Fatalf("Expected limits %s, got %s", tc.expected, limits.String())
+			}
+		})
+	}
+}
+
+func TestPathLimitsAfterNormalisation(t *testing.T) {
+	// NFC decomposes U+0344, so the normalised segment is longer than the raw one
+	options := SchemaOptions{MaxLength: 4, Validation: ValidationOptions{NormaliseNFC: true}}
+	compiled, err := CreateSchemaWithOptions(`+/+`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	for input, index := range map[string]int{"\u0344\u0344\u0344/a": 1, "\u0344\u0344\u0344\u0344\u0344/a": 0} {
+		err := compiled.Validate(input, &ValidationContext{})
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Reason != ReasonPathTooLong || validationErr.Index != index {
+			t.Fatalf("Expected %s to be too long from segment %d, got %v", input, index, err)
+		}
+	}
+}
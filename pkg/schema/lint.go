@@ -0,0 +1,291 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/hydridity/Schematic/pkg/parser"
+)
+
+type LintSeverity string
+
+const (
+	// LintError marks a schema that fails to compile, can never match, or fails at runtime.
+	LintError LintSeverity = "error"
+	// LintWarning marks a schema that works but is most likely not what was intended.
+	LintWarning LintSeverity = "warning"
+)
+
+// LintFinding is a problem found in a schema, positioned in the schema text.
+type LintFinding struct {
+	Severity LintSeverity
+	// Line and Column of the offending part of the schema, both starting at 1.
+	Line    int
+	Column  int
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", f.Line, f.Column, f.Severity, f.Message)
+}
+
+// Lint checks a schema for constructs that can never match or that only fail during validation. Modifier calls are
+// checked against the predefined modifiers and the modifiers of the context, which may be nil.
+func Lint(schemaStr string, context *ValidationContext) []LintFinding {
+	return LintWithOptions(schemaStr, context, SchemaOptions{})
+}
+
+// LintWithOptions is like Lint, checking modifier calls against the registry of the options instead of the
+// predefined modifiers.
+func LintWithOptions(schemaStr string, context *ValidationContext, options SchemaOptions) []LintFinding {
+	parserObj, err := newParser()
+	if err != nil {
+		return []LintFinding{{Severity: LintError, Line: 1, Column: 1, Message: err.Error()}}
+	}
+	schemaAst, err := parserObj.ParseString("", schemaStr)
+	if err != nil {
+		var schemaErr *SchemaError
+		if errors.As(newSchemaError(schemaStr, err), &schemaErr) {
+			return []LintFinding{{Severity: LintError, Line: schemaErr.Line, Column: schemaErr.Column, Message: schemaErr.Message}}
+		}
+		return []LintFinding{{Severity: LintError, Line: 1, Column: 1, Message: err.Error()}}
+	}
+	separateBareCaptures(schemaAst, options)
+
+	linter := schemaLinter{context: context, modifiers: options.modifiers(), checkModifiers: true}
+	linter.lint(schemaAst)
+	return linter.findings
+}
+
+// lintErrors returns the findings that prevent a schema from being created. Calls of the registered modifiers are
+// type-checked, while other modifiers are only known once the schema is validated.
+func lintErrors(schemaAst *parser.SchemaAST, options SchemaOptions) []LintFinding {
+	linter := schemaLinter{modifiers: options.modifiers()}
+	linter.lint(schemaAst)
+
+	errorFindings := make([]LintFinding, 0)
+	for _, finding := range linter.findings {
+		if finding.Severity == LintError {
+			errorFindings = append(errorFindings, finding)
+		}
+	}
+	return errorFindings
+}
+
+type schemaLinter struct {
+	context   *ValidationContext
+	modifiers *ModifierRegistry
+	// checkModifiers reports calls of modifiers which are neither registered nor in the context.
+	checkModifiers bool
+	findings       []LintFinding
+	// captures holds the names captured so far, in the order the schema matches them.
+	captures []string
+}
+
+func (l *schemaLinter) addCapture(capture *parser.Capture) {
+	if capture == nil {
+		return
+	}
+	l.captures = append(l.captures, capture.Name)
+}
+
+func (l *schemaLinter) checkBackref(pos lexer.Position, name string) {
+	if !slices.Contains(l.captures, name) {
+		l.report(LintError, pos, "backreference to '%s', which is not captured before it", name)
+	}
+}
+
+func (l *schemaLinter) report(severity LintSeverity, pos lexer.Position, format string, args ...any) {
+	l.findings = append(l.findings, LintFinding{
+		Severity: severity,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *schemaLinter) lint(schemaAst *parser.SchemaAST) {
+	afterMultiWildcard := false
+	for i, part := range schemaAst.Parts {
+		switch {
+		case i == 0 && part.Separator != "":
+			l.report(LintError, part.Pos, "schema must not start with the separator '%s'", part.Separator)
+		case i > 0 && part.Separator == "":
+			l.report(LintError, part.Pos, "segment must be preceded by a separator")
+		}
+		if part.Wildcard != nil {
+			l.lintWildcard(part.Wildcard, afterMultiWildcard)
+			afterMultiWildcard = part.Wildcard.Symbol == "*" && part.Wildcard.Quantifier == nil
+			continue
+		}
+		afterMultiWildcard = false
+		if part.Group != nil {
+			l.lintGroup(part.Group)
+			l.addCapture(part.Group.Capture)
+			continue
+		}
+		if part.Negation != nil {
+			l.lintNegation(part.Negation)
+			continue
+		}
+		if part.Condition != nil {
+			l.lintCondition(part.Condition)
+			continue
+		}
+		for _, piece := range part.Pieces {
+			l.lintPiece(piece)
+			if len(part.Pieces) > 1 {
+				l.lintCompositeVar(piece)
+			}
+			l.addCapture(piece.Capture)
+		}
+	}
+}
+
+func (l *schemaLinter) lintWildcard(wildcard *parser.Wildcard, afterMultiWildcard bool) {
+	minSegments, maxSegments := wildcard.Bounds()
+	if quantifier := wildcard.Quantifier; quantifier != nil {
+		minimum, maximum := minSegments, maxSegments
+		switch {
+		case quantifier.Min == nil && !quantifier.Range:
+			l.report(LintError, quantifier.Pos, "quantifier {} gives no number of segments")
+		case maximum >= 0 && minimum > maximum:
+			l.report(LintError, quantifier.Pos, "quantifier minimum %d is greater than its maximum %d", minimum, maximum)
+		case maximum == 0:
+			l.report(LintWarning, quantifier.Pos, "quantifier %s never consumes a segment", quantifier.String())
+		}
+	}
+
+	if wildcard.Limits != nil {
+		if _, err := parseSegmentLimits(*wildcard.Limits); err != nil {
+			l.report(LintError, wildcard.Pos, "%v", err)
+		}
+	}
+
+	// Another wildcard which may match nothing after '*' can't add anything to it, and with one consuming a varying
+	// number of segments which segments each of them consumes, and captures, is ambiguous
+	switch {
+	case afterMultiWildcard && minSegments == 0:
+		l.report(LintWarning, wildcard.Pos, "wildcard directly after '*' is redundant")
+	case afterMultiWildcard && minSegments != maxSegments:
+		l.report(LintWarning, wildcard.Pos, "wildcard directly after '*' makes it ambiguous which segments each of them consumes")
+	}
+
+	if wildcard.Exclusion != nil {
+		l.lintNegation(wildcard.Exclusion)
+	}
+	l.addCapture(wildcard.Capture)
+}
+
+func (l *schemaLinter) lintNegation(negation *parser.Negation) {
+	if negation.Group != nil {
+		if negation.Group.Capture != nil {
+			l.report(LintWarning, negation.Group.Pos, "capture '%s' inside a negation is never recorded", negation.Group.Capture.Name)
+		}
+		// Only the names captured before the negation can be referred to after it
+		captures := l.captures
+		l.lintGroup(negation.Group)
+		l.captures = captures
+	} else {
+		l.lintPiece(&parser.Piece{Pos: negation.Pos, Var: negation.Var, VarSet: negation.VarSet, Backref: negation.Backref, Literal: negation.Literal, Regex: negation.Regex, Type: negation.Type, Flags: negation.Flags})
+	}
+	l.addCapture(negation.Capture)
+}
+
+func (l *schemaLinter) lintGroup(group *parser.Group) {
+	sources := make([]string, 0, len(group.Branches))
+	for _, branch := range group.Branches {
+		source := branch.Source()
+		if slices.Contains(sources, source) {
+			l.report(LintWarning, branch.Parts[0].Pos, "branch '%s' is repeated in the group", source)
+		}
+		sources = append(sources, source)
+		l.lint(branch)
+	}
+}
+
+func (l *schemaLinter) lintCondition(condition *parser.Condition) {
+	if !slices.Contains(l.captures, condition.Capture) {
+		l.report(LintError, condition.Pos, "condition on '%s', which is not captured before it", condition.Capture)
+	}
+	if condition.Regex != nil {
+		if _, err := regexp.Compile(*condition.Regex); err != nil {
+			l.report(LintError, condition.Pos, "invalid regex '%s': %v", *condition.Regex, err)
+		}
+	}
+	l.lint(condition.Then)
+	if condition.Else != nil {
+		l.lint(condition.Else)
+	}
+}
+
+func (l *schemaLinter) lintPiece(piece *parser.Piece) {
+	switch {
+	case piece.Flags == "":
+	case piece.Flags != "i":
+		l.report(LintError, piece.Pos, "unknown flag '%s'", piece.Flags)
+	case piece.Regex != nil:
+		l.report(LintError, piece.Pos, "flag 'i' is not supported on regexes, use (?i) in the pattern instead")
+	case piece.Type != nil:
+		l.report(LintError, piece.Pos, "flag 'i' is not supported on typed segments")
+	}
+
+	switch {
+	case piece.Regex != nil:
+		for _, reference := range captureReference.FindAllStringSubmatch(*piece.Regex, -1) {
+			l.checkBackref(piece.Pos, reference[1])
+		}
+		// References are replaced with literal text, so the pattern is valid without them
+		if _, err := regexp.Compile(captureReference.ReplaceAllString(*piece.Regex, "")); err != nil {
+			l.report(LintError, piece.Pos, "invalid regex '%s': %v", *piece.Regex, err)
+		}
+	case piece.Backref != nil:
+		l.checkBackref(piece.Pos, piece.Backref.Name)
+	case piece.Type != nil:
+		if _, err := compileType(*piece.Type); err != nil {
+			l.report(LintError, piece.Pos, "%v", err)
+		}
+	case piece.Var != nil:
+		for _, modifier := range piece.Var.Modifiers {
+			l.lintModifier(modifier, false)
+		}
+	case piece.VarSet != nil:
+		for _, modifier := range piece.VarSet.Modifiers {
+			l.lintModifier(modifier, true)
+		}
+	}
+}
+
+// lintCompositeVar warns about a variable without braces whose name takes in a '-', which more likely starts the
+// text after the variable in a composite segment, e.g. app-$env-db refers to the variable 'env-db'.
+func (l *schemaLinter) lintCompositeVar(piece *parser.Piece) {
+	if piece.Var == nil || piece.Var.Braced {
+		return
+	}
+	if name, rest, found := strings.Cut(piece.Var.Name, "-"); found {
+		l.report(LintWarning, piece.Pos, "variable '%s' in a composite segment includes '-%s', write '${%s}-%s' if the variable is '%s'", piece.Var.Name, rest, name, rest, name)
+	}
+}
+
+func (l *schemaLinter) lintModifier(modifier *parser.Modifier, set bool) {
+	if l.context != nil {
+		if _, found := l.context.VariableModifiers[modifier.Func]; found {
+			// Signatures of custom modifiers are not known
+			return
+		}
+	}
+	spec, found := l.modifiers.Lookup(modifier.Func)
+	if !found {
+		if l.checkModifiers {
+			l.report(LintError, modifier.Pos, "modifier '%s' is not registered", modifier.Func)
+		}
+		return
+	}
+	if err := spec.checkCall(compileArgs(modifier.Args), set); err != nil {
+		l.report(LintError, modifier.Pos, "%v", err)
+	}
+}
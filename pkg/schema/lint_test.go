@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	customModifiers := map[string]VariableModifierFunction{
+		"custom": func(input []string, args []string) ([]string, error) { return input, nil },
+	}
+
+	cases := []struct {
+		name     string
+		schema   string
+		findings []string
+	}{
+		{"Clean schema", "$gitlab_path.strip_last_prefix(\"helm-\")/$[technologies]/+{1,2}/*", nil},
+		{"Custom modifier", "$gitlab_path.custom()", nil},
+		{"Constraint after multi wildcard", "*/literal/+", nil},
+		{"Set after multi wildcard", "secret/*/$[technologies]", nil},
+		{"Exact wildcard after multi wildcard", "a/*/+{2}", nil},
+		{"Group after multi wildcard", "*:{path}/(a|b)/*/!x", nil},
+		{"Open wildcard after multi wildcard", "*/+{2,}:{rest}", []string{"1:3: warning: wildcard directly after '*' makes it ambiguous which segments each of them consumes"}},
+		{"Ranged wildcard after multi wildcard", "*:{head}/+{1,3}", []string{"1:10: warning: wildcard directly after '*' makes it ambiguous"}},
+		{"Wildcard after multi wildcard", "*/*", []string{"1:3: warning: wildcard directly after '*' is redundant"}},
+		{"Optional wildcard after multi wildcard", "*/+{0,2}", []string{"1:3: warning: wildcard directly after '*' is redundant"}},
+		{"Quantifier minimum above maximum", "+{3,1}", []string{"1:2: error: quantifier minimum 3 is greater than its maximum 1"}},
+		{"Exact and open quantifiers", "+{2}/+{2,}/*{,3}/+{,}", nil},
+		{"Open minimum of one or more", "+{,0}", []string{"1:2: error: quantifier minimum 1 is greater than its maximum 0"}},
+		{"Quantifier without bounds", "+{}", []string{"1:2: error: quantifier {} gives no number of segments"}},
+		{"Empty quantifier", "a/+{0,0}", []string{"1:4: warning: quantifier {0} never consumes a segment"}},
+		{"Quantified wildcard after multi wildcard", "*/*{1,}", []string{"1:3: warning: wildcard directly after '*' makes it ambiguous"}},
+		{"Invalid regex", "a/#[a-z#", []string{"1:3: error: invalid regex '[a-z'"}},
+		{"Predefined modifiers", `$var.take_last(2).strip_suffix(".git", -1).upper()/$var.split("-").join("_").default("x")`, nil},
+		{"Optional modifier argument", `a/$var.replace("_", "-", 0, 1)`, []string{"1:8: error: modifier 'replace' expects between 2 and 3 arguments, got 4"}},
+		{"Modifier argument type", `a/$var.take_first("2")`, []string{"1:8: error: modifier 'take_first' expects an int as argument 1 (n), got a string"}},
+		{"Variadic modifier argument type", `$var.strip_last_prefix("a", "b", 3)`, []string{"1:6: error: modifier 'strip_last_prefix' expects a string as argument 3 (prefix), got an int"}},
+		{"Set modifiers", `$[technologies].lower().without("legacy")/$[projects].only("a", "b").strip_last_prefix("helm-")`, nil},
+		{"Set modifier argument type", `a/$[set].without(1)`, []string{"1:10: error: modifier 'without' expects a string as argument 1 (member), got an int"}},
+		{"Variable taking '-' in composite", `apps/app-$env-db`, []string{"1:10: warning: variable 'env-db' in a composite segment includes '-db', write '${env}-db' if the variable is 'env'"}},
+		{"Braced variable in composite", `apps/app-${env}-db`, nil},
+		{"Variable with '-' as segment", `apps/$env-db`, nil},
+		{"Set modifier on variable", `a/$var.without("legacy")`, []string{"1:8: error: modifier 'without' only applies to variable sets"}},
+		{"Unregistered modifier", "$var.strip_first_prefix(\"a\")", []string{"1:6: error: modifier 'strip_first_prefix' is not registered"}},
+		{"Missing modifier argument", "a/$var.strip_last_prefix()", []string{"1:8: error: modifier 'strip_last_prefix' expects at least 1 arguments, got 0"}},
+		{"Parse error", "a//b", []string{"1:3: error:"}},
+		{"Leading separator", "/a", []string{"1:1: error: schema must not start with the separator '/'"}},
+		{"Missing separator", "+a", []string{"1:2: error: segment must be preceded by a separator"}},
+		{"Repeated branch", "(a|b/+|a)/c", []string{"1:8: warning: branch 'a' is repeated in the group"}},
+		{"Invalid negated regex", "a/+!#[#", []string{"1:4: error: invalid regex '['"}},
+		{"Capture inside negation", "a/!(b|c):name", []string{"1:4: warning: capture 'name' inside a negation is never recorded"}},
+		{"Backreferences", `+:{team}/\team/\{team}-db/#^\k<team>$#/!\team`, nil},
+		{"Backreference before capture", `\team/+:{team}`, []string{"1:1: error: backreference to 'team', which is not captured before it"}},
+		{"Regex reference before capture", `#^\k<team>$#/+:{team}`, []string{"1:1: error: backreference to 'team', which is not captured before it"}},
+		{"Condition", `+:{env}/(?env=prod:$[prod_technologies]|$[technologies])`, nil},
+		{"Condition before capture", `(?env=prod:a|b)/+:{env}`, []string{"1:1: error: condition on 'env', which is not captured before it"}},
+		{"Condition with invalid regex", `+:{env}/(?env=#(#:a)`, []string{"1:9: error: invalid regex '('"}},
+		{"Condition branch", `+:{env}/(?env=prod:+{3,1})`, []string{"1:21: error: quantifier minimum 3 is greater than its maximum 1"}},
+		{"Typed segments", `<uuid>/v<semver>/<int:1..>/<date>/!<dns_label>`, nil},
+		{"Unknown type", `a/<guid>`, []string{"1:3: error: unknown type 'guid'"}},
+		{"Type arguments", `a/x-<uuid:4>`, []string{"1:5: error: type 'uuid' takes no arguments"}},
+		{"Empty int range", `a/<int:9..1>`, []string{"1:3: error: type 'int': minimum 9 is greater than the maximum 1"}},
+		{"Wildcard limits", `+<len 3..40, charset [a-z0-9-]>/+{2}<charset [,.]>/*<len ..63>`, nil},
+		{"Invalid wildcard limit", `a/+<size 3>`, []string{"1:3: error: invalid segment limit 'size 3', expected len or charset"}},
+		{"Empty wildcard length", `a/+<len 5..2>`, []string{"1:3: error: segment limit len: minimum 5 is greater than the maximum 2"}},
+		{"Invalid charset", `a/+<charset a-z>`, []string{"1:3: error: segment limit charset expects a character class"}},
+		{"Flags", `secret~i/$env~i/$[technologies]~i/!tmp~i`, nil},
+		{"Unknown flag", `a/secret~x`, []string{"1:3: error: unknown flag 'x'"}},
+		{"Flag on regex", `a/#^s#~i`, []string{"1:3: error: flag 'i' is not supported on regexes"}},
+		{"Backreference to negated capture", `!(a|b):x/\x`, []string{"1:2: warning: capture", "1:10: error: backreference to 'x'"}},
+		{"Capture without braces", "+/+:latest", nil},
+		{"Literal after ':'", "+/+:name:$[tags]", nil},
+		{"Captures with braces after ':'", "+/+:{name}:$[tags]:{tag}", nil},
+		{"Invalid branch", "x/(a|+{2,1})", []string{"1:7: error: quantifier minimum 2 is greater than its maximum 1"}},
+		{"Multiple findings", "+{3,1}/$var.nope()", []string{
+			"1:2: error: quantifier minimum 3 is greater than its maximum 1",
+			"1:13: error: modifier 'nope' is not registered",
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			findings := Lint(tc.schema, &ValidationContext{VariableModifiers: customModifiers})
+			if len(findings) != len(tc.findings) {
+				t.Fatalf("Expected %d findings, got %d: %v", len(tc.findings), len(findings), findings)
+			}
+			for i, finding := range findings {
+				if !strings.HasPrefix(finding.String(), tc.findings[i]) {
+					t.Fatalf("Expected finding %q, got %q", tc.findings[i], finding.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSchemaRejectsLintErrors(t *testing.T) {
+	for _, schemaStr := range []string{"+{3,1}", "a/+{}"} {
+		if _, err := CreateSchema(schemaStr); err == nil {
+			t.Fatalf("Expected CreateSchema to reject %s", schemaStr)
+		}
+	}
+	// Calls of registered modifiers are type-checked
+	if _, err := CreateSchema(`$var.segment("1")`); err == nil {
+		t.Fatalf("Expected CreateSchema to reject a string as the index of segment")
+	}
+	// Modifiers are provided by the validation context, so CreateSchema can't reject them
+	if _, err := CreateSchema("$var.custom()"); err != nil {
+		t.Fatalf("Expected CreateSchema to accept an unknown modifier: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package schema
+
+// Continuation is called by a constraint once it has consumed a prefix of the input. It receives the remaining
+// segments and should return nil if the rest of the schema matched them.
+type Continuation func(rest []string) error
+
+// matchSequence matches the constraints one after another, backtracking into earlier constraints whenever a later
+// one fails. The continuation is called with whatever input the whole sequence left over.
+//
+// Separators, unless nil, hold the separator in front of each constraint. When the input was split at several
+// separators, it has to be delimited by that one in front of the first segment the constraint consumes. The
+// separators in front of constraints which consumed nothing are pending until the next segment, which may be
+// delimited by any of them.
+//
+// Every sequence matched within one frame continues with the same continuation, so the rest of a schema fails on
+// the same segments no matter how the constraints before it matched, unless it refers to their captures. Failures
+// are remembered by the input left and what was captured, so that backtracking doesn't match the rest again, which
+// would take exponential time with several '*'.
+func matchSequence(constraints []Constraint, separators []string, pending []string, path []string, frame sequenceFrame, context *ValidationContext, next Continuation) error {
+	if err := context.state.spend(); err != nil {
+		return err
+	}
+	if len(constraints) == 0 {
+		return context.state.stamp(next(path))
+	}
+	if frame.id == 0 {
+		return matchConstraints(constraints, separators, pending, path, frame, context, next)
+	}
+	key := context.state.failureKey(frame, len(constraints), path, pending)
+	if err := context.state.failures[key]; err != nil {
+		return err
+	}
+	err := matchConstraints(constraints, separators, pending, path, frame, context, next)
+	if err != nil {
+		context.state.rememberFailure(key, err)
+	}
+	return err
+}
+
+// matchConstraints matches the first constraint and continues with the sequence of the others.
+func matchConstraints(constraints []Constraint, separators []string, pending []string, path []string, frame sequenceFrame, context *ValidationContext, next Continuation) error {
+	checksSeparators := separators != nil && context.state.checksSeparators()
+	if len(constraints) == 1 && !checksSeparators {
+		// The last constraint continues with the rest of the schema directly, advance stamps its failures
+		return context.state.stamp(constraints[0].Match(path, context, next))
+	}
+	var rest []string
+	if checksSeparators {
+		if separators[0] != "" {
+			pending = append(pending[:len(pending):len(pending)], separators[0])
+		}
+		rest = separators[1:]
+	}
+	err := constraints[0].Match(path, context, func(remaining []string) error {
+		if len(remaining) == len(path) {
+			return matchSequence(constraints[1:], rest, pending, remaining, frame, context, next)
+		}
+		if err := context.state.checkSeparator(constraints[0], path, pending); err != nil {
+			return err
+		}
+		return matchSequence(constraints[1:], rest, nil, remaining, frame, context, next)
+	})
+	return context.state.stamp(err)
+}
+
+// backtracks reports whether more than one of the constraints may consume a varying number of segments, which is
+// when a sequence can end up matching its rest on the same segments over and over.
+func backtracks(constraints []Constraint) bool {
+	varying := 0
+	for _, constraint := range constraints {
+		if consumesVarying(constraint) {
+			varying++
+		}
+	}
+	return varying > 1
+}
+
+func consumesVarying(constraint Constraint) bool {
+	switch c := constraint.(type) {
+	case *LiteralConstraint, *RegexConstraint, *CompositeConstraint, *BackreferenceConstraint, *UUIDConstraint,
+		*IntConstraint, *SemverConstraint, *DateConstraint, *DNSLabelConstraint:
+		return false
+	case *WildcardSingleConstraint:
+		return c.Min != c.Max
+	case *NegatedConstraint:
+		return consumesVarying(c.Constraint)
+	case *CaptureConstraint:
+		return consumesVarying(c.Constraint)
+	default:
+		// Variables, sets, groups and conditions, as well as constraints of other packages
+		return true
+	}
+}
+
+// consumeFirst returns the remainder of the first successful alternative of the constraint. It backs the
+// Consume method of every constraint.
+func consumeFirst(constraint Constraint, path []string, context *ValidationContext) ([]string, error) {
+	var result []string
+	err := constraint.Match(path, context, func(rest []string) error {
+		result = rest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -2,10 +2,11 @@ package schema
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
-func modifierStripLastPrefix(variable []string, args []string) ([]string, error) {
+func modifierStripLastPrefix(variable []string, args []ModifierArg) ([]string, error) {
 	if len(args) <= 0 {
 		return nil, fmt.Errorf("strip_last_prefix: expected at least 1 argument, found %d", len(args))
 	}
@@ -16,8 +17,8 @@ func modifierStripLastPrefix(variable []string, args []string) ([]string, error)
 
 	for _, prefix := range args {
 		lastIndex := len(variable) - 1
-		if strings.HasPrefix(variable[lastIndex], prefix) {
-			variable[lastIndex] = variable[lastIndex][len(prefix):]
+		if strings.HasPrefix(variable[lastIndex], prefix.Text) {
+			variable[lastIndex] = variable[lastIndex][len(prefix.Text):]
 			break // Strip only one prefix
 		}
 	}
@@ -25,8 +26,245 @@ func modifierStripLastPrefix(variable []string, args []string) ([]string, error)
 	return variable, nil
 }
 
-func getPredefinedModifiers() map[string]VariableModifierFunction {
-	return map[string]VariableModifierFunction{
-		"strip_last_prefix": modifierStripLastPrefix,
+// modifierMaybeStripLastPrefix yields the value with the first of the prefixes the last segment starts with
+// stripped, followed by the value as it is, e.g. for projects which are being renamed.
+func modifierMaybeStripLastPrefix(variable []string, args []ModifierArg) ([][]string, error) {
+	stripped, err := modifierStripLastPrefix(slices.Clone(variable), args)
+	if err != nil {
+		return nil, err
 	}
+	return [][]string{stripped, variable}, nil
+}
+
+// modifierStripPrefix strips the prefix from the segment at the index given as second argument, negative indexes
+// counting from the end, or from every segment when there is no index. Segments without the prefix are kept as
+// they are.
+func modifierStripPrefix(variable []string, args []ModifierArg) ([]string, error) {
+	return mapSegments("strip_prefix", variable, args[1:], func(segment string) string {
+		return strings.TrimPrefix(segment, args[0].Text)
+	})
+}
+
+// modifierStripSuffix is like modifierStripPrefix for a suffix.
+func modifierStripSuffix(variable []string, args []ModifierArg) ([]string, error) {
+	return mapSegments("strip_suffix", variable, args[1:], func(segment string) string {
+		return strings.TrimSuffix(segment, args[0].Text)
+	})
+}
+
+// modifierLower converts every segment, or the one at the index given as argument, to lower case.
+func modifierLower(variable []string, args []ModifierArg) ([]string, error) {
+	return mapSegments("lower", variable, args, strings.ToLower)
+}
+
+// modifierUpper converts every segment, or the one at the index given as argument, to upper case.
+func modifierUpper(variable []string, args []ModifierArg) ([]string, error) {
+	return mapSegments("upper", variable, args, strings.ToUpper)
+}
+
+// modifierReplace replaces every occurrence of the first argument with the second one, in every segment or the one
+// at the index given as third argument.
+func modifierReplace(variable []string, args []ModifierArg) ([]string, error) {
+	return mapSegments("replace", variable, args[2:], func(segment string) string {
+		return strings.ReplaceAll(segment, args[0].Text, args[1].Text)
+	})
+}
+
+// modifierRegexReplace replaces every match of the regex with the replacement, which may refer to submatches as $1
+// or ${name}, in every segment or the one at the index given as third argument.
+func modifierRegexReplace(variable []string, args []ModifierArg) ([]string, error) {
+	regex, err := args[0].Regexp()
+	if err != nil {
+		return nil, fmt.Errorf("regex_replace: invalid regex '%s': %w", args[0].Text, err)
+	}
+	return mapSegments("regex_replace", variable, args[2:], func(segment string) string {
+		return regex.ReplaceAllString(segment, args[1].Text)
+	})
+}
+
+// modifierTakeFirst keeps the first n segments, or all of them when there are fewer.
+func modifierTakeFirst(variable []string, args []ModifierArg) ([]string, error) {
+	n, err := countArg("take_first", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return variable[:min(n, len(variable))], nil
+}
+
+// modifierTakeLast keeps the last n segments, or all of them when there are fewer.
+func modifierTakeLast(variable []string, args []ModifierArg) ([]string, error) {
+	n, err := countArg("take_last", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return variable[len(variable)-min(n, len(variable)):], nil
+}
+
+// modifierDropFirst removes the first n segments, leaving none when there are fewer.
+func modifierDropFirst(variable []string, args []ModifierArg) ([]string, error) {
+	n, err := countArg("drop_first", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return variable[min(n, len(variable)):], nil
+}
+
+// modifierDropLast removes the last n segments, leaving none when there are fewer.
+func modifierDropLast(variable []string, args []ModifierArg) ([]string, error) {
+	n, err := countArg("drop_last", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return variable[:len(variable)-min(n, len(variable))], nil
+}
+
+// modifierSegment keeps only the segment at the index, negative indexes counting from the end.
+func modifierSegment(variable []string, args []ModifierArg) ([]string, error) {
+	index, err := segmentIndex("segment", variable, args[0].Int)
+	if err != nil {
+		return nil, err
+	}
+	return variable[index : index+1], nil
+}
+
+// modifierSplit splits every segment further at the separator given as argument.
+func modifierSplit(variable []string, args []ModifierArg) ([]string, error) {
+	if args[0].Text == "" {
+		return nil, fmt.Errorf("split: separator must not be empty")
+	}
+	result := make([]string, 0, len(variable))
+	for _, segment := range variable {
+		result = append(result, strings.Split(segment, args[0].Text)...)
+	}
+	return result, nil
+}
+
+// modifierJoin joins all segments into a single one, separated by the argument.
+func modifierJoin(variable []string, args []ModifierArg) ([]string, error) {
+	return []string{strings.Join(variable, args[0].Text)}, nil
+}
+
+// modifierDefault replaces an empty variable value, one without segments or with empty segments only, with the
+// argument.
+func modifierDefault(variable []string, args []ModifierArg) ([]string, error) {
+	for _, segment := range variable {
+		if segment != "" {
+			return variable, nil
+		}
+	}
+	return []string{args[0].Text}, nil
+}
+
+// modifierWithout removes the members given as arguments from a variable set. Members which are not in the set are
+// ignored.
+func modifierWithout(members []string, args []ModifierArg) ([]string, error) {
+	return slices.DeleteFunc(members, func(member string) bool {
+		return slices.ContainsFunc(args, func(arg ModifierArg) bool { return arg.Text == member })
+	}), nil
+}
+
+// modifierOnly keeps only the members of a variable set given as arguments.
+func modifierOnly(members []string, args []ModifierArg) ([]string, error) {
+	return slices.DeleteFunc(members, func(member string) bool {
+		return !slices.ContainsFunc(args, func(arg ModifierArg) bool { return arg.Text == member })
+	}), nil
+}
+
+// mapSegments applies the function to every segment, or only to the one at the index when one is given.
+func mapSegments(name string, variable []string, index []ModifierArg, f func(string) string) ([]string, error) {
+	result := make([]string, len(variable))
+	copy(result, variable)
+	if len(index) > 0 {
+		i, err := segmentIndex(name, variable, index[0].Int)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = f(result[i])
+		return result, nil
+	}
+	for i, segment := range result {
+		result[i] = f(segment)
+	}
+	return result, nil
+}
+
+// countArg returns an argument standing for a number of segments, which must not be negative.
+func countArg(name string, arg ModifierArg) (int, error) {
+	if arg.Int < 0 {
+		return 0, fmt.Errorf("%s: expected a number of segments, got %d", name, arg.Int)
+	}
+	return arg.Int, nil
+}
+
+// segmentIndex resolves an index into the segments of the variable, negative indexes counting from the end.
+func segmentIndex(name string, variable []string, index int) (int, error) {
+	resolved := index
+	if resolved < 0 {
+		resolved += len(variable)
+	}
+	if resolved < 0 || resolved >= len(variable) {
+		return 0, fmt.Errorf("%s: segment index %d is out of range for %d segments", name, index, len(variable))
+	}
+	return resolved, nil
+}
+
+var predefinedModifiers = getPredefinedModifiers()
+
+func getPredefinedModifiers() *ModifierRegistry {
+	index := ModifierParam{Name: "index", Type: IntType, Optional: true}
+	count := ModifierParam{Name: "n", Type: IntType}
+	specs := []ModifierSpec{
+		{Name: "strip_last_prefix", Function: modifierStripLastPrefix, Variadic: true,
+			Params: []ModifierParam{{Name: "prefix", Type: StringType}},
+			Doc:    "Removes the first of the prefixes the last segment starts with."},
+		{Name: "maybe_strip_last_prefix", Candidates: modifierMaybeStripLastPrefix, Variadic: true,
+			Params: []ModifierParam{{Name: "prefix", Type: StringType}},
+			Doc:    "Yields the value with the first of the prefixes the last segment starts with removed, and the value as it is."},
+		{Name: "strip_prefix", Function: modifierStripPrefix,
+			Params: []ModifierParam{{Name: "prefix", Type: StringType}, index},
+			Doc:    "Removes the prefix from every segment, or only from the one at the index."},
+		{Name: "strip_suffix", Function: modifierStripSuffix,
+			Params: []ModifierParam{{Name: "suffix", Type: StringType}, index},
+			Doc:    "Removes the suffix from every segment, or only from the one at the index."},
+		{Name: "lower", Function: modifierLower, Params: []ModifierParam{index},
+			Doc: "Converts every segment, or only the one at the index, to lower case."},
+		{Name: "upper", Function: modifierUpper, Params: []ModifierParam{index},
+			Doc: "Converts every segment, or only the one at the index, to upper case."},
+		{Name: "replace", Function: modifierReplace,
+			Params: []ModifierParam{{Name: "old", Type: StringType}, {Name: "new", Type: StringType}, index},
+			Doc:    "Replaces every occurrence of old with new in every segment, or only in the one at the index."},
+		{Name: "regex_replace", Function: modifierRegexReplace,
+			Params: []ModifierParam{{Name: "regex", Type: StringType, Regex: true}, {Name: "replacement", Type: StringType}, index},
+			Doc:    "Replaces every match of the regex in every segment, or only in the one at the index; the replacement may refer to submatches as $1."},
+		{Name: "take_first", Function: modifierTakeFirst, Params: []ModifierParam{count},
+			Doc: "Keeps the first n segments."},
+		{Name: "take_last", Function: modifierTakeLast, Params: []ModifierParam{count},
+			Doc: "Keeps the last n segments."},
+		{Name: "drop_first", Function: modifierDropFirst, Params: []ModifierParam{count},
+			Doc: "Removes the first n segments."},
+		{Name: "drop_last", Function: modifierDropLast, Params: []ModifierParam{count},
+			Doc: "Removes the last n segments."},
+		{Name: "segment", Function: modifierSegment, Params: []ModifierParam{{Name: "index", Type: IntType}},
+			Doc: "Keeps only the segment at the index, negative indexes counting from the end."},
+		{Name: "split", Function: modifierSplit, Params: []ModifierParam{{Name: "separator", Type: StringType}},
+			Doc: "Splits every segment further at the separator."},
+		{Name: "join", Function: modifierJoin, Params: []ModifierParam{{Name: "separator", Type: StringType}},
+			Doc: "Joins all segments into one, separated by the separator."},
+		{Name: "default", Function: modifierDefault, Params: []ModifierParam{{Name: "value", Type: StringType}},
+			Doc: "Replaces an empty value with the given one."},
+		{Name: "without", Set: modifierWithout, Variadic: true,
+			Params: []ModifierParam{{Name: "member", Type: StringType}},
+			Doc:    "Removes the members from a variable set."},
+		{Name: "only", Set: modifierOnly, Variadic: true,
+			Params: []ModifierParam{{Name: "member", Type: StringType}},
+			Doc:    "Keeps only the members of a variable set which are given."},
+	}
+
+	registry := NewModifierRegistry()
+	for _, spec := range specs {
+		if err := registry.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return registry
 }
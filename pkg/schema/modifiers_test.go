@@ -9,14 +9,23 @@ import (
 
 type modifierTestCase struct {
 	input      []string
-	args       []string
+	args       []ModifierArg
 	shouldFail bool
 	output     []string
 }
 
-func (tc *modifierTestCase) test(f VariableModifierFunction, t *testing.T) {
-	t.Run(fmt.Sprintf("%s(%s)", strings.Join(tc.input, "/"), strings.Join(tc.args, "/")), func(t *testing.T) {
-		res, err := f(tc.input, tc.args)
+// test calls the predefined modifier with the arguments, after checking them against its signature.
+func (tc *modifierTestCase) test(name string, t *testing.T) {
+	t.Run(fmt.Sprintf("%s(%s)", strings.Join(tc.input, "/"), strings.Join(stringArgs(tc.args), "/")), func(t *testing.T) {
+		spec, found := predefinedModifiers.Lookup(name)
+		if !found {
+			t.Fatalf("Modifier %s is not registered", name)
+		}
+		err := spec.check(tc.args)
+		var res []string
+		if err == nil {
+			res, err = spec.Function(tc.input, tc.args)
+		}
 		if !tc.shouldFail {
 			if err != nil {
 				t.Fatalf("Modifier failed: %v", err)
@@ -37,54 +46,245 @@ func TestStripLastPrefix(t *testing.T) {
 	cases := []modifierTestCase{
 		{
 			input:      []string{"prefix-a"},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{"a"},
 		},
 		{
 			input:      []string{"other", "prefix-a"},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{"other", "a"},
 		},
 		{
 			input:      []string{"other", "prefix-a"},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{"other", "a"},
 		},
 		{
 			input:      []string{"other", "prefix-a"},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{"other", "a"},
 		},
 		{
 			input:      []string{"a"},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{"a"},
 		},
 		{
 			input:      []string{"other", "a"},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{"other", "a"},
 		},
 		{
 			input:      []string{},
-			args:       []string{"prefix-"},
+			args:       []ModifierArg{StringArg("prefix-")},
 			shouldFail: false,
 			output:     []string{},
 		},
 
 		{
 			input:      []string{"a"},
-			args:       []string{},
+			args:       []ModifierArg{},
 			shouldFail: true,
 		},
 	}
 	for _, testCase := range cases {
-		testCase.test(modifierStripLastPrefix, t)
+		testCase.test("strip_last_prefix", t)
+	}
+}
+
+func TestStripPrefixAndSuffix(t *testing.T) {
+	cases := []modifierTestCase{
+		{input: []string{"team-a", "team-b"}, args: []ModifierArg{StringArg("team-")}, output: []string{"a", "b"}},
+		{input: []string{"team-a", "team-b"}, args: []ModifierArg{StringArg("team-"), IntArg(0)}, output: []string{"a", "team-b"}},
+		{input: []string{"team-a", "team-b"}, args: []ModifierArg{StringArg("team-"), IntArg(-1)}, output: []string{"team-a", "b"}},
+		{input: []string{"a", "team-b"}, args: []ModifierArg{StringArg("x-")}, output: []string{"a", "team-b"}},
+		{input: []string{}, args: []ModifierArg{StringArg("team-")}, output: []string{}},
+		{input: []string{"team-a"}, args: []ModifierArg{StringArg("team-"), IntArg(1)}, shouldFail: true},
+		{input: []string{"team-a"}, args: []ModifierArg{StringArg("team-"), StringArg("last")}, shouldFail: true},
+		{input: []string{"team-a"}, args: []ModifierArg{}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("strip_prefix", t)
+	}
+
+	cases = []modifierTestCase{
+		{input: []string{"db.prod", "cache.prod"}, args: []ModifierArg{StringArg(".prod")}, output: []string{"db", "cache"}},
+		{input: []string{"db.prod", "cache.prod"}, args: []ModifierArg{StringArg(".prod"), IntArg(-2)}, output: []string{"db", "cache.prod"}},
+		{input: []string{"db"}, args: []ModifierArg{StringArg(".prod"), IntArg(-2)}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("strip_suffix", t)
+	}
+}
+
+func TestCaseModifiers(t *testing.T) {
+	cases := []modifierTestCase{
+		{input: []string{"Group1", "Helm-Project"}, args: []ModifierArg{}, output: []string{"group1", "helm-project"}},
+		{input: []string{"Group1", "Helm-Project"}, args: []ModifierArg{IntArg(1)}, output: []string{"Group1", "helm-project"}},
+		{input: []string{"Group1"}, args: []ModifierArg{IntArg(0), IntArg(1)}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("lower", t)
+	}
+
+	cases = []modifierTestCase{
+		{input: []string{"eu", "west"}, args: []ModifierArg{}, output: []string{"EU", "WEST"}},
+		{input: []string{"eu", "west"}, args: []ModifierArg{IntArg(0)}, output: []string{"EU", "west"}},
+	}
+	for _, testCase := range cases {
+		testCase.test("upper", t)
+	}
+}
+
+func TestReplaceModifiers(t *testing.T) {
+	cases := []modifierTestCase{
+		{input: []string{"helm_project_1", "a_b"}, args: []ModifierArg{StringArg("_"), StringArg("-")}, output: []string{"helm-project-1", "a-b"}},
+		{input: []string{"helm_project_1", "a_b"}, args: []ModifierArg{StringArg("_"), StringArg("-"), IntArg(1)}, output: []string{"helm_project_1", "a-b"}},
+		{input: []string{"a"}, args: []ModifierArg{StringArg("_")}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("replace", t)
+	}
+
+	cases = []modifierTestCase{
+		{input: []string{"project-123", "other"}, args: []ModifierArg{StringArg(`-\d+$`), StringArg("")}, output: []string{"project", "other"}},
+		{input: []string{"v1.2.3"}, args: []ModifierArg{StringArg(`^v(\d+)\..*$`), StringArg("major-$1")}, output: []string{"major-1"}},
+		{input: []string{"a-1", "b-2"}, args: []ModifierArg{StringArg(`\d`), StringArg("x"), IntArg(-1)}, output: []string{"a-1", "b-x"}},
+		{input: []string{"a"}, args: []ModifierArg{StringArg(`(`), StringArg("")}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("regex_replace", t)
+	}
+}
+
+func TestSegmentSelectionModifiers(t *testing.T) {
+	path := func() []string { return []string{"group", "subgroup", "project"} }
+	modifiers := []struct {
+		name  string
+		cases []modifierTestCase
+	}{
+		{"take_first", []modifierTestCase{
+			{input: path(), args: []ModifierArg{IntArg(2)}, output: []string{"group", "subgroup"}},
+			{input: path(), args: []ModifierArg{IntArg(5)}, output: path()},
+			{input: path(), args: []ModifierArg{IntArg(0)}, output: []string{}},
+			{input: path(), args: []ModifierArg{IntArg(-1)}, shouldFail: true},
+			{input: path(), args: []ModifierArg{StringArg("two")}, shouldFail: true},
+		}},
+		{"take_last", []modifierTestCase{
+			{input: path(), args: []ModifierArg{IntArg(2)}, output: []string{"subgroup", "project"}},
+			{input: path(), args: []ModifierArg{IntArg(5)}, output: path()},
+		}},
+		{"drop_first", []modifierTestCase{
+			{input: path(), args: []ModifierArg{IntArg(1)}, output: []string{"subgroup", "project"}},
+			{input: path(), args: []ModifierArg{IntArg(5)}, output: []string{}},
+		}},
+		{"drop_last", []modifierTestCase{
+			{input: path(), args: []ModifierArg{IntArg(1)}, output: []string{"group", "subgroup"}},
+			{input: path(), args: []ModifierArg{IntArg(3)}, output: []string{}},
+			{input: path(), args: []ModifierArg{}, shouldFail: true},
+		}},
+		{"segment", []modifierTestCase{
+			{input: path(), args: []ModifierArg{IntArg(1)}, output: []string{"subgroup"}},
+			{input: path(), args: []ModifierArg{IntArg(-1)}, output: []string{"project"}},
+			{input: path(), args: []ModifierArg{IntArg(3)}, shouldFail: true},
+			{input: path(), args: []ModifierArg{IntArg(-4)}, shouldFail: true},
+		}},
+	}
+	for _, modifier := range modifiers {
+		t.Run(modifier.name, func(t *testing.T) {
+			for _, testCase := range modifier.cases {
+				testCase.test(modifier.name, t)
+			}
+		})
+	}
+}
+
+func TestSplitAndJoin(t *testing.T) {
+	cases := []modifierTestCase{
+		{input: []string{"eu-west-1", "prod"}, args: []ModifierArg{StringArg("-")}, output: []string{"eu", "west", "1", "prod"}},
+		{input: []string{"a"}, args: []ModifierArg{StringArg("")}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("split", t)
+	}
+
+	cases = []modifierTestCase{
+		{input: []string{"group", "project"}, args: []ModifierArg{StringArg("-")}, output: []string{"group-project"}},
+		{input: []string{"group"}, args: []ModifierArg{StringArg("-")}, output: []string{"group"}},
+		{input: []string{"group"}, args: []ModifierArg{}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("join", t)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	cases := []modifierTestCase{
+		{input: []string{""}, args: []ModifierArg{StringArg("shared")}, output: []string{"shared"}},
+		{input: []string{}, args: []ModifierArg{StringArg("shared")}, output: []string{"shared"}},
+		{input: []string{"team"}, args: []ModifierArg{StringArg("shared")}, output: []string{"team"}},
+		{input: []string{""}, args: []ModifierArg{}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test("default", t)
+	}
+}
+
+func TestMaybeStripLastPrefix(t *testing.T) {
+	spec, _ := predefinedModifiers.Lookup("maybe_strip_last_prefix")
+	variable := []string{"group1", "helm-project1"}
+	candidates, err := spec.call(variable, []ModifierArg{StringArg("ansible-"), StringArg("helm-")})
+	if err != nil {
+		t.Fatalf("Modifier failed: %v", err)
+	}
+	if len(candidates) != 2 || !slices.Equal(candidates[0], []string{"group1", "project1"}) || !slices.Equal(candidates[1], variable) {
+		t.Fatalf("Expected the stripped and the unchanged value, got %v", candidates)
+	}
+	if variable[1] != "helm-project1" {
+		t.Fatalf("Modifier changed the value in place")
+	}
+}
+
+func TestSetFilters(t *testing.T) {
+	members := []string{"postgres", "kafka", "group1/legacy", "mssql"}
+	cases := []struct {
+		name     string
+		args     []ModifierArg
+		expected []string
+	}{
+		{"without", []ModifierArg{StringArg("kafka"), StringArg("group1/legacy"), StringArg("oracle")}, []string{"postgres", "mssql"}},
+		{"without", []ModifierArg{StringArg("legacy")}, members},
+		{"only", []ModifierArg{StringArg("mssql"), StringArg("kafka")}, []string{"kafka", "mssql"}},
+		{"only", []ModifierArg{StringArg("oracle")}, []string{}},
+	}
+	for _, tc := range cases {
+		spec, _ := predefinedModifiers.Lookup(tc.name)
+		result, err := spec.Set(slices.Clone(members), tc.args)
+		if err != nil {
+			t.Fatalf("%s failed: %v", tc.name, err)
+		}
+		if !slices.Equal(result, tc.expected) {
+			t.Fatalf("Expected %s(%v) to give %v, got %v", tc.name, tc.args, tc.expected, result)
+		}
+	}
+}
+
+func TestRegexReplaceCompiledOnce(t *testing.T) {
+	compiled, err := CreateSchema(`$project.regex_replace("^helm-(.*)$", "$1")`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	modifier := compiled.(*Impl).Constraints[0].(*VariableConstraint).Modifiers[0]
+	if modifier.Args[0].regex == nil {
+		t.Fatalf("Regex argument was not compiled with the schema")
+	}
+	if _, err := CreateSchema(`$project.regex_replace("(", "")`); err == nil {
+		t.Fatalf("Expected CreateSchema to reject an invalid regex argument")
 	}
 }
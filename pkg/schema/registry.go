@@ -0,0 +1,337 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hydridity/Schematic/pkg/parser"
+)
+
+// ArgType is the type of a modifier argument.
+type ArgType int
+
+const (
+	StringType ArgType = iota
+	IntType
+	BoolType
+	// ListType is a list of strings, e.g. ["legacy", "tmp"].
+	ListType
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case IntType:
+		return "int"
+	case BoolType:
+		return "bool"
+	case ListType:
+		return "list"
+	default:
+		return "string"
+	}
+}
+
+// article returns the name of the type with its indefinite article, e.g. an int.
+func (t ArgType) article() string {
+	if t == IntType {
+		return "an int"
+	}
+	return "a " + t.String()
+}
+
+// ModifierArg is an argument of a modifier call, e.g. "helm-", 2, true or ["legacy", "tmp"]. Only the field of its
+// type is set.
+type ModifierArg struct {
+	Type ArgType
+	Text string
+	Int  int
+	Bool bool
+	List []string
+
+	// regex is the compiled Text of an argument for a Regex parameter, set when the schema is created.
+	regex *regexp.Regexp
+}
+
+func StringArg(text string) ModifierArg {
+	return ModifierArg{Type: StringType, Text: text}
+}
+
+func IntArg(value int) ModifierArg {
+	return ModifierArg{Type: IntType, Int: value}
+}
+
+func BoolArg(value bool) ModifierArg {
+	return ModifierArg{Type: BoolType, Bool: value}
+}
+
+func ListArg(items ...string) ModifierArg {
+	return ModifierArg{Type: ListType, List: items}
+}
+
+// String renders the argument for traces, strings without quotes, e.g. helm- or [legacy, tmp].
+func (a ModifierArg) String() string {
+	switch a.Type {
+	case IntType:
+		return strconv.Itoa(a.Int)
+	case BoolType:
+		return strconv.FormatBool(a.Bool)
+	case ListType:
+		return "[" + strings.Join(a.List, ", ") + "]"
+	default:
+		return a.Text
+	}
+}
+
+// Regexp returns the argument as a regular expression. It's compiled once when the argument is for a Regex
+// parameter of a schema, and on every call otherwise.
+func (a ModifierArg) Regexp() (*regexp.Regexp, error) {
+	if a.regex != nil {
+		return a.regex, nil
+	}
+	return regexp.Compile(a.Text)
+}
+
+// stringArgs renders the arguments for a VariableModifierFunction, the items of a list being separate arguments.
+func stringArgs(args []ModifierArg) []string {
+	strs := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg.Type == ListType {
+			strs = append(strs, arg.List...)
+			continue
+		}
+		strs = append(strs, arg.String())
+	}
+	return strs
+}
+
+// compileArgs converts the arguments of a parsed modifier call.
+func compileArgs(args []*parser.Argument) []ModifierArg {
+	compiled := make([]ModifierArg, 0, len(args))
+	for _, arg := range args {
+		switch {
+		case arg.Text != nil:
+			compiled = append(compiled, StringArg(*arg.Text))
+		case arg.Int != nil:
+			compiled = append(compiled, IntArg(int(*arg.Int)))
+		case arg.Bool != nil:
+			compiled = append(compiled, BoolArg(bool(*arg.Bool)))
+		default:
+			compiled = append(compiled, ListArg(arg.Items...))
+		}
+	}
+	return compiled
+}
+
+// ModifierFunction is a modifier registered with its signature in a ModifierRegistry. It's only called with
+// arguments of the declared number and types, and otherwise works like a VariableModifierFunction.
+type ModifierFunction func(variable []string, args []ModifierArg) ([]string, error)
+
+// CandidateModifierFunction is a modifier yielding several alternative values, e.g. a project path with and without a
+// prefix during a migration. The input may match any of them. The values must not share their backing arrays.
+type CandidateModifierFunction func(variable []string, args []ModifierArg) ([][]string, error)
+
+// SetModifierFunction is a modifier of a variable set as a whole, e.g. a filter of its members. It gets the text of
+// all members and returns the members the set is matched with.
+type SetModifierFunction func(members []string, args []ModifierArg) ([]string, error)
+
+// ModifierParam is a parameter of a modifier.
+type ModifierParam struct {
+	Name string
+	Type ArgType
+	// Optional parameters may be left out, they follow all required ones.
+	Optional bool
+	// Regex requires a string argument to be a regular expression. It's compiled once with the schema, and the
+	// function gets it from ModifierArg.Regexp.
+	Regex bool
+}
+
+// ModifierSpec declares a modifier: its name, parameters, documentation and function.
+type ModifierSpec struct {
+	Name   string
+	Params []ModifierParam
+	// Variadic lets the last parameter be repeated any number of times.
+	Variadic bool
+	// Doc describes the modifier in a sentence, shown by ModifierRegistry.Help.
+	Doc string
+	// Function or Candidates modifies the value of a variable, or each member of a variable set. Set only applies
+	// to variable sets and modifies all members at once. Exactly one of them is set.
+	Function   ModifierFunction
+	Candidates CandidateModifierFunction
+	Set        SetModifierFunction
+}
+
+// call applies the modifier to the variable, returning its candidate values.
+func (s *ModifierSpec) call(variable []string, args []ModifierArg) ([][]string, error) {
+	if s.Candidates != nil {
+		return s.Candidates(variable, args)
+	}
+	if s.Set != nil {
+		return nil, fmt.Errorf("modifier '%s' only applies to variable sets", s.Name)
+	}
+	result, err := s.Function(variable, args)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{result}, nil
+}
+
+// Signature renders the name and parameters of the modifier, e.g. strip_prefix(prefix string, index? int).
+func (s *ModifierSpec) Signature() string {
+	params := make([]string, 0, len(s.Params))
+	for i, param := range s.Params {
+		name := param.Name
+		if param.Optional {
+			name += "?"
+		}
+		if s.Variadic && i == len(s.Params)-1 {
+			name += "..."
+		}
+		params = append(params, name+" "+param.Type.String())
+	}
+	return fmt.Sprintf("%s(%s)", s.Name, strings.Join(params, ", "))
+}
+
+// arity returns the number of arguments the modifier accepts, the maximum being negative when it's variadic.
+func (s *ModifierSpec) arity() (int, int) {
+	minimum := 0
+	for _, param := range s.Params {
+		if !param.Optional {
+			minimum++
+		}
+	}
+	if s.Variadic {
+		return minimum, -1
+	}
+	return minimum, len(s.Params)
+}
+
+// checkCall returns why the modifier can't be called with the arguments, on a variable set or on a variable.
+func (s *ModifierSpec) checkCall(args []ModifierArg, set bool) error {
+	if s.Set != nil && !set {
+		return fmt.Errorf("modifier '%s' only applies to variable sets", s.Name)
+	}
+	return s.check(args)
+}
+
+// check returns why the arguments don't fit the parameters of the modifier.
+func (s *ModifierSpec) check(args []ModifierArg) error {
+	minimum, maximum := s.arity()
+	if len(args) < minimum || (maximum >= 0 && len(args) > maximum) {
+		return fmt.Errorf("modifier '%s' expects %s, got %d", s.Name, arityString(minimum, maximum), len(args))
+	}
+	for i, arg := range args {
+		param := s.Params[min(i, len(s.Params)-1)]
+		if arg.Type != param.Type {
+			return fmt.Errorf("modifier '%s' expects %s as argument %d (%s), got %s", s.Name, param.Type.article(), i+1, param.Name, arg.Type.article())
+		}
+		if param.Regex {
+			if _, err := regexp.Compile(arg.Text); err != nil {
+				return fmt.Errorf("modifier '%s' expects a regular expression as argument %d (%s): %v", s.Name, i+1, param.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compileRegexes compiles the arguments for Regex parameters, which check accepted.
+func (s *ModifierSpec) compileRegexes(args []ModifierArg) {
+	for i := range args {
+		if param := s.Params[min(i, len(s.Params)-1)]; param.Regex {
+			args[i].regex = regexp.MustCompile(args[i].Text)
+		}
+	}
+}
+
+func arityString(minimum int, maximum int) string {
+	switch {
+	case maximum < 0:
+		return fmt.Sprintf("at least %d arguments", minimum)
+	case minimum == maximum:
+		return fmt.Sprintf("%d arguments", minimum)
+	default:
+		return fmt.Sprintf("between %d and %d arguments", minimum, maximum)
+	}
+}
+
+// ModifierRegistry holds the modifiers a schema may call along with their signatures, which CreateSchema checks the
+// calls against. A registry must not be changed while schemas created with it are in use.
+type ModifierRegistry struct {
+	modifiers map[string]*ModifierSpec
+}
+
+func NewModifierRegistry() *ModifierRegistry {
+	return &ModifierRegistry{modifiers: make(map[string]*ModifierSpec)}
+}
+
+// DefaultModifierRegistry returns a new registry holding the predefined modifiers, which can be extended.
+func DefaultModifierRegistry() *ModifierRegistry {
+	registry := NewModifierRegistry()
+	for name, spec := range predefinedModifiers.modifiers {
+		registry.modifiers[name] = spec
+	}
+	return registry
+}
+
+// Register adds a modifier to the registry, failing when its name is taken or its signature is invalid.
+func (r *ModifierRegistry) Register(spec ModifierSpec) error {
+	functions := 0
+	for _, set := range []bool{spec.Function != nil, spec.Candidates != nil, spec.Set != nil} {
+		if set {
+			functions++
+		}
+	}
+	if spec.Name == "" || functions != 1 {
+		return fmt.Errorf("modifier needs a name and exactly one of a function, a candidates function or a set function")
+	}
+	if _, found := r.modifiers[spec.Name]; found {
+		return fmt.Errorf("modifier '%s' is already registered", spec.Name)
+	}
+	if spec.Variadic && len(spec.Params) == 0 {
+		return fmt.Errorf("variadic modifier '%s' needs a parameter", spec.Name)
+	}
+	for i := 1; i < len(spec.Params); i++ {
+		if spec.Params[i-1].Optional && !spec.Params[i].Optional {
+			return fmt.Errorf("parameter '%s' of modifier '%s' is required but follows an optional one", spec.Params[i].Name, spec.Name)
+		}
+	}
+	r.modifiers[spec.Name] = &spec
+	return nil
+}
+
+// Lookup returns the modifier registered under the name.
+func (r *ModifierRegistry) Lookup(name string) (*ModifierSpec, bool) {
+	spec, found := r.modifiers[name]
+	return spec, found
+}
+
+// Modifiers returns the registered modifiers ordered by name.
+func (r *ModifierRegistry) Modifiers() []*ModifierSpec {
+	specs := make([]*ModifierSpec, 0, len(r.modifiers))
+	for _, spec := range r.modifiers {
+		specs = append(specs, spec)
+	}
+	slices.SortFunc(specs, func(a, b *ModifierSpec) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return specs
+}
+
+// Help lists the signature of every registered modifier, each followed by its documentation.
+func (r *ModifierRegistry) Help() string {
+	builder := strings.Builder{}
+	for _, spec := range r.Modifiers() {
+		builder.WriteString(fmt.Sprintf("  %s\n        %s\n", spec.Signature(), spec.Doc))
+	}
+	return builder.String()
+}
+
+// modifiers returns the registry of the options, the predefined modifiers when there is none.
+func (o SchemaOptions) modifiers() *ModifierRegistry {
+	if o.Modifiers == nil {
+		return predefinedModifiers
+	}
+	return o.Modifiers
+}
@@ -0,0 +1,108 @@
+package schema
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestModifierRegistry(t *testing.T) {
+	registry := DefaultModifierRegistry()
+	err := registry.Register(ModifierSpec{
+		Name:   "remove",
+		Params: []ModifierParam{{Name: "values", Type: ListType}, {Name: "keep_empty", Type: BoolType, Optional: true}},
+		Doc:    "Removes the segments in the list.",
+		Function: func(variable []string, args []ModifierArg) ([]string, error) {
+			return slices.DeleteFunc(variable, func(segment string) bool {
+				return slices.Contains(args[0].List, segment) || (segment == "" && (len(args) < 2 || !args[1].Bool))
+			}), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot register modifier: %v", err)
+	}
+	if _, found := predefinedModifiers.Lookup("remove"); found {
+		t.Fatalf("Registering a modifier changed the predefined modifiers")
+	}
+
+	spec, found := registry.Lookup("remove")
+	if !found {
+		t.Fatalf("Registered modifier not found")
+	}
+	if signature := spec.Signature(); signature != "remove(values list, keep_empty? bool)" {
+		t.Fatalf("Unexpected signature %s", signature)
+	}
+	if help := registry.Help(); !strings.Contains(help, "  remove(values list, keep_empty? bool)\n        Removes the segments in the list.\n") ||
+		!strings.Contains(help, "strip_last_prefix(prefix... string)") {
+		t.Fatalf("Unexpected help text:\n%s", help)
+	}
+
+	options := SchemaOptions{Modifiers: registry}
+	compiled, err := CreateSchemaWithOptions(`$gitlab_path.split("-").remove(["helm", "legacy"], true)/+`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	store := &mapVariableStore{variables: map[string]string{"gitlab_path": "group1/helm-legacy-project1"}}
+	if err := compiled.Validate("group1/project1/admin", &ValidationContext{VariableStore: store}); err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+
+	for _, schemaStr := range []string{`$a.remove("helm")`, `$a.remove(["helm"], "yes")`, `$a.remove()`} {
+		if _, err := CreateSchemaWithOptions(schemaStr, options); err == nil {
+			t.Fatalf("Expected CreateSchema to reject %s", schemaStr)
+		}
+	}
+	if findings := LintWithOptions(`$a.remove(["helm"]).strip_last_prefix("x")`, nil, options); len(findings) != 0 {
+		t.Fatalf("Expected no findings, got %v", findings)
+	}
+}
+
+func TestRegisterInvalidModifiers(t *testing.T) {
+	identity := func(variable []string, args []ModifierArg) ([]string, error) { return variable, nil }
+	cases := []struct {
+		name string
+		spec ModifierSpec
+	}{
+		{"Taken name", ModifierSpec{Name: "lower", Function: identity}},
+		{"Missing function", ModifierSpec{Name: "f"}},
+		{"Two functions", ModifierSpec{Name: "f", Function: identity, Candidates: func(variable []string, args []ModifierArg) ([][]string, error) {
+			return [][]string{variable}, nil
+		}}},
+		{"Function and set function", ModifierSpec{Name: "f", Function: identity, Set: func(members []string, args []ModifierArg) ([]string, error) {
+			return members, nil
+		}}},
+		{"Variadic without parameters", ModifierSpec{Name: "f", Function: identity, Variadic: true}},
+		{"Required after optional", ModifierSpec{Name: "f", Function: identity, Params: []ModifierParam{
+			{Name: "a", Type: IntType, Optional: true}, {Name: "b", Type: IntType},
+		}}},
+	}
+	registry := DefaultModifierRegistry()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := registry.Register(tc.spec); err == nil {
+				t.Fatalf("Expected the modifier to be rejected")
+			}
+		})
+	}
+}
+
+func TestCustomModifierArguments(t *testing.T) {
+	var received []string
+	modifiers := map[string]VariableModifierFunction{
+		"record": func(variable []string, args []string) ([]string, error) {
+			received = args
+			return variable, nil
+		},
+	}
+	compiled, err := CreateSchema(`$name.record("a", 2, true, ["x", "y"])`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	store := &mapVariableStore{variables: map[string]string{"name": "n"}}
+	if err := compiled.Validate("n", &ValidationContext{VariableStore: store, VariableModifiers: modifiers}); err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+	if expected := []string{"a", "2", "true", "x", "y"}; !slices.Equal(received, expected) {
+		t.Fatalf("Expected the arguments %v, got %v", expected, received)
+	}
+}
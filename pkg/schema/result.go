@@ -0,0 +1,390 @@
+package schema
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MatchStep describes the segments consumed by one constraint of the schema.
+type MatchStep struct {
+	Constraint Constraint
+	// Index of the first consumed segment in the input.
+	Index int
+	// Segments consumed by the constraint, empty for wildcards and optional groups that matched nothing.
+	Segments []string
+	// Member of the variable set, or branch of the group or condition, which matched, empty when an optional group
+	// was left out. For a VariableConstraint whose modifiers yielded several candidate values, it's the candidate
+	// which matched. Other constraints have no member.
+	Member string
+}
+
+// MatchResult describes how an input was matched by a schema.
+type MatchResult struct {
+	// Input is the input as it was given, and NormalisedInput the input that was matched, after the normalisations.
+	// Segments, and the Index of each step, refer to the normalised input. A ValidationError refers to the segments of
+	// the normalised input too, but Diagnose points at the failing segment in the raw input.
+	Input           string
+	NormalisedInput string
+	Segments        []string
+	// Steps holds one entry per constraint of the schema, in schema order.
+	Steps []MatchStep
+	// Captures maps names given in the schema, e.g. +:{role}, to the segments they captured. Captures inside a
+	// composite segment hold the single piece of text they matched.
+	Captures map[string][]string
+	// Normalisations lists the changes made to the input before it was matched, see ValidationOptions.
+	Normalisations []Normalisation
+
+	// values holds the captures with their segments joined by the separators found between them in the input.
+	values map[string]string
+}
+
+// Capture returns the value of a named capture, with multiple segments joined by the separators found between them
+// in the input, or by '/' for a result which wasn't returned by a match.
+func (r *MatchResult) Capture(name string) (string, bool) {
+	if value, found := r.values[name]; found {
+		return value, true
+	}
+	segments, found := r.Captures[name]
+	if !found {
+		return "", false
+	}
+	return strings.Join(segments, "/"), true
+}
+
+type capturedValue struct {
+	name     string
+	segments []string
+	// index of the first captured segment in the input.
+	index int
+}
+
+// matchState records the progress of a match while backtracking. Entries are pushed before continuing and popped
+// when the continuation fails, so once the whole match succeeds it holds exactly the successful path.
+type matchState struct {
+	segments []string
+	// separator is the default separator of the schema, and delimiters the separator found in front of each segment.
+	// Delimiters are nil when the input was split at the default separator only.
+	separator  string
+	delimiters []string
+	// normalisations made to the input before it was split into the segments.
+	normalisations []Normalisation
+	// record is set when steps and captures should be kept for a MatchResult.
+	record bool
+	// depth is greater than zero while matching inside a sub-schema or a composite segment, whose constraints are
+	// not steps of the top-level schema.
+	depth int
+	// progress counts the constraints, at any depth, that matched on the way to the current position. It ranks
+	// failures of alternative attempts.
+	progress int
+	steps    []MatchStep
+	captures []capturedValue
+	trace    *Trace
+	// context is passed to the constraints while matching.
+	context ValidationContext
+	// frames counts the frames started so far, and failures holds the failures remembered in them, see
+	// matchSequence. Once a schema refers to captures, the failures of the frames started after it are remembered
+	// along with the captures.
+	frames             int
+	failures           map[failureKey]error
+	referencesCaptures bool
+	// maxSteps is the number of sequences the match may try, unlimited when zero, and exhausted the failure once
+	// they have been tried.
+	maxSteps  int
+	spent     int
+	exhausted *ValidationError
+}
+
+// sequenceFrame is one call of a schema, whose sequences all continue with the same continuation. Failures are only
+// remembered in frames with an id, see matchSequence.
+type sequenceFrame struct {
+	id int
+}
+
+type failureKey struct {
+	frame       int
+	constraints int
+	remaining   int
+	// pending holds the pending separators, and captures the values captured so far when they matter.
+	pending  string
+	captures string
+}
+
+type matchMark struct {
+	steps    int
+	captures int
+}
+
+// enter starts matching inside a sub-schema or a composite segment. Trace events are added to the scope node until
+// the matching leaves it again, or dropped if the scope is nil.
+func (s *matchState) enter(scope *TraceNode) {
+	if s != nil {
+		s.depth++
+		s.pushTraceScope(scope)
+	}
+}
+
+func (s *matchState) leave() {
+	if s != nil {
+		s.depth--
+		s.popTraceScope()
+	}
+}
+
+func (s *matchState) mark() matchMark {
+	if s == nil {
+		return matchMark{}
+	}
+	return matchMark{steps: len(s.steps), captures: len(s.captures)}
+}
+
+// reset drops everything recorded after the mark. It's needed where a constraint matched part of its input
+// successfully but then failed as a whole.
+func (s *matchState) reset(mark matchMark) {
+	if s != nil {
+		s.steps = s.steps[:mark.steps]
+		s.captures = s.captures[:mark.captures]
+	}
+}
+
+// advance records that the constraint consumed the input between path and rest, then continues with the rest of
+// the schema. Constraints call it instead of calling the continuation directly. Failures of the continuation which
+// aren't stamped yet are stamped with the progress including the constraint.
+func (s *matchState) advance(constraint Constraint, path []string, rest []string, member string, next Continuation) (err error) {
+	if s == nil {
+		return next(rest)
+	}
+	s.progress++
+	defer func() { s.progress-- }()
+
+	node := s.traceConsumed(constraint, path, rest, member)
+	if node != nil {
+		defer func() { node.Backtracked = err != nil }()
+	}
+
+	if !s.record || s.depth > 0 {
+		return s.stamp(next(rest))
+	}
+	s.steps = append(s.steps, MatchStep{
+		Constraint: constraint,
+		Index:      len(s.segments) - len(path),
+		Segments:   path[:len(path)-len(rest)],
+		Member:     member,
+	})
+	err = s.stamp(next(rest))
+	if err != nil {
+		s.steps = s.steps[:len(s.steps)-1]
+	}
+	return err
+}
+
+// newFrame starts a call of the schema. Failures are remembered when the schema can backtrack, except while tracing,
+// as the trace shows every attempt.
+func (s *matchState) newFrame(schema *Impl) sequenceFrame {
+	if s == nil {
+		return sequenceFrame{}
+	}
+	if schema.referencesCaptures {
+		s.referencesCaptures = true
+	}
+	if s.trace != nil || !schema.backtracks {
+		return sequenceFrame{}
+	}
+	s.frames++
+	return sequenceFrame{id: s.frames}
+}
+
+// failureKey identifies the match of the last constraints of the frame on the path.
+func (s *matchState) failureKey(frame sequenceFrame, constraints int, path []string, pending []string) failureKey {
+	key := failureKey{frame: frame.id, constraints: constraints, remaining: len(path)}
+	if len(pending) > 0 {
+		key.pending = strings.Join(pending, "\x00")
+	}
+	if s.referencesCaptures && len(s.captures) > 0 {
+		builder := strings.Builder{}
+		for _, captured := range s.captures {
+			builder.WriteString(captured.name)
+			for _, segment := range captured.segments {
+				builder.WriteString("/" + strconv.Itoa(len(segment)) + ":" + segment)
+			}
+			builder.WriteString(";")
+		}
+		key.captures = builder.String()
+	}
+	return key
+}
+
+func (s *matchState) rememberFailure(key failureKey, err error) {
+	if s.failures == nil {
+		s.failures = make(map[failureKey]error)
+	}
+	s.failures[key] = err
+}
+
+// spend counts a step of the match, and fails once the steps allowed by the schema are used up. The failure is that
+// of the whole input rather than of one segment.
+func (s *matchState) spend() error {
+	if s == nil || s.maxSteps <= 0 {
+		return nil
+	}
+	if s.spent < s.maxSteps {
+		s.spent++
+		return nil
+	}
+	if s.exhausted == nil {
+		s.exhausted = newValidationError(nil, s.segments, ReasonTooComplex, nil, "matching gave up after %d steps", s.maxSteps)
+	}
+	return s.exhausted
+}
+
+func (s *matchState) currentProgress() int {
+	if s == nil {
+		return 0
+	}
+	return s.progress
+}
+
+// stamp marks a failure with the progress made before it, unless a deeper failure point already did.
+func (s *matchState) stamp(err error) error {
+	validationErr, ok := err.(*ValidationError)
+	if s != nil && ok && !validationErr.stamped {
+		validationErr.progress = s.progress
+		validationErr.stamped = true
+		s.traceRejected(validationErr)
+	}
+	return err
+}
+
+// separatorOrDefault returns the default separator of the schema being matched, or '/' without a match in progress.
+func (s *matchState) separatorOrDefault() string {
+	if s == nil || s.separator == "" {
+		return "/"
+	}
+	return s.separator
+}
+
+// checksSeparators reports whether the input was split at several separators, which makes them worth checking.
+func (s *matchState) checksSeparators() bool {
+	return s != nil && s.delimiters != nil
+}
+
+// delimiter returns the separator found in front of the segment at the index.
+func (s *matchState) delimiter(index int) string {
+	if s.delimiters == nil {
+		return s.separator
+	}
+	return s.delimiters[index]
+}
+
+// checkSeparator verifies that the input consumed by the constraint starts after one of the pending separators.
+// The start of the input, and the start of a sub-schema which has no separators of its own, aren't checked.
+func (s *matchState) checkSeparator(constraint Constraint, path []string, pending []string) error {
+	if s == nil || len(pending) == 0 {
+		return nil
+	}
+	index := len(s.segments) - len(path)
+	if index == 0 {
+		return nil
+	}
+	delimiter := s.delimiter(index)
+	if slices.Contains(pending, delimiter) {
+		return nil
+	}
+	err := newValidationError(constraint, path, ReasonSeparatorMismatch, pending, "expected separator '%s' in front of '%s', got '%s'", strings.Join(pending, "' or '"), path[0], delimiter)
+	return s.stamp(err)
+}
+
+// joinedSegments limits the number of segments a wildcard may consume to those joined by the default separator, as
+// only a separator of the schema may stand between segments of different constraints. The error explains the limit.
+func (s *matchState) joinedSegments(constraint Constraint, path []string, upper int, rejected error) (int, error) {
+	if !s.checksSeparators() {
+		return upper, rejected
+	}
+	start := len(s.segments) - len(path)
+	for i := 1; i < upper; i++ {
+		if delimiter := s.delimiter(start + i); delimiter != s.separator {
+			return i, newValidationError(constraint, path[i:], ReasonSeparatorMismatch, []string{s.separator}, "expected separator '%s' in front of '%s', got '%s'", s.separator, path[i], delimiter)
+		}
+	}
+	return upper, rejected
+}
+
+// capture records the segments consumed between path and rest under the name while the rest of the schema is
+// matched. Captures are kept even without a MatchResult, as backreferences refer to them.
+func (s *matchState) capture(name string, path []string, rest []string, next func() error) error {
+	if s == nil {
+		return next()
+	}
+	segments := path[:len(path)-len(rest)]
+	s.captures = append(s.captures, capturedValue{name: name, segments: segments, index: len(s.segments) - len(path)})
+	err := next()
+	if err != nil {
+		s.captures = s.captures[:len(s.captures)-1]
+	}
+	return err
+}
+
+// captured returns the segments of the latest capture with the name.
+func (s *matchState) captured(name string) ([]string, bool) {
+	if s == nil {
+		return nil, false
+	}
+	for i := len(s.captures) - 1; i >= 0; i-- {
+		if s.captures[i].name == name {
+			return s.captures[i].segments, true
+		}
+	}
+	return nil, false
+}
+
+func (s *matchState) result(input string) *MatchResult {
+	captures := make(map[string][]string, len(s.captures))
+	values := make(map[string]string, len(s.captures))
+	for _, captured := range s.captures {
+		captures[captured.name] = captured.segments
+		values[captured.name] = s.joinFrom(captured.index, captured.segments)
+	}
+	normalised := input
+	if len(s.normalisations) > 0 {
+		normalised = s.join()
+	}
+	return &MatchResult{
+		Input:           input,
+		NormalisedInput: normalised,
+		Segments:        s.segments,
+		Steps:           s.steps,
+		Captures:        captures,
+		Normalisations:  s.normalisations,
+		values:          values,
+	}
+}
+
+// join puts the segments together again with the separators found in front of them.
+func (s *matchState) join() string {
+	return s.joinFrom(0, s.segments)
+}
+
+// joinConsumed puts the segments consumed between path and rest together with the separators found in front of them.
+func (s *matchState) joinConsumed(path []string, rest []string) string {
+	consumed := path[:len(path)-len(rest)]
+	if s == nil {
+		return strings.Join(consumed, "/")
+	}
+	return s.joinFrom(len(s.segments)-len(path), consumed)
+}
+
+// joinFrom puts segments of the input, the first of them at the index, together with the separators found in front
+// of them. Without a match in progress they're joined by '/'.
+func (s *matchState) joinFrom(index int, segments []string) string {
+	if s == nil || len(segments) < 2 {
+		return strings.Join(segments, s.separatorOrDefault())
+	}
+	builder := strings.Builder{}
+	for i, segment := range segments {
+		if i > 0 {
+			builder.WriteString(s.delimiter(index + i))
+		}
+		builder.WriteString(segment)
+	}
+	return builder.String()
+}
@@ -0,0 +1,384 @@
+package schema
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type expectedStep struct {
+	index    int
+	segments []string
+	member   string
+}
+
+type matchTestCase struct {
+	name     string
+	schema   string
+	input    string
+	steps    []expectedStep
+	captures map[string]string
+}
+
+func (tc *matchTestCase) test(store VariableStore, t *testing.T) {
+	t.Run(tc.name, func(t *testing.T) {
+		compiled, err := CreateSchema(tc.schema)
+		if err != nil {
+			t.Fatalf("Cannot create schema %s: %v", tc.schema, err)
+		}
+		result, err := compiled.Match(tc.input, &ValidationContext{VariableStore: store})
+		if err != nil {
+			t.Fatalf("Match of %s against %s failed: %v", tc.input, tc.schema, err)
+		}
+
+		if len(result.Steps) != len(tc.steps) {
+			t.Fatalf("Expected %d steps, got %d", len(tc.steps), len(result.Steps))
+		}
+		for i, step := range result.Steps {
+			expected := tc.steps[i]
+			if step.Index != expected.index || !slices.Equal(step.Segments, expected.segments) || step.Member != expected.member {
+				t.Fatalf("Step %d (%s): expected segments \"%s\" at %d with member \"%s\", got \"%s\" at %d with member \"%s\"",
+					i, step.Constraint.String(),
+					strings.Join(expected.segments, "/"), expected.index, expected.member,
+					strings.Join(step.Segments, "/"), step.Index, step.Member)
+			}
+		}
+
+		if len(result.Captures) != len(tc.captures) {
+			t.Fatalf("Expected %d captures, got %v", len(tc.captures), result.Captures)
+		}
+		for name, expected := range tc.captures {
+			value, found := result.Capture(name)
+			if !found || value != expected {
+				t.Fatalf("Expected capture %s to be \"%s\", got \"%s\"", name, expected, value)
+			}
+		}
+	})
+}
+
+func TestMatchResult(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"mssql", "wso/+{0,1}", "postgres"},
+			"roles":        {"admin", "reader"},
+		},
+	}
+	cases := []matchTestCase{
+		{
+			name:   "StepsForEveryConstraint",
+			schema: `$gitlab_path.strip_last_prefix("helm-")/$[technologies]/*/+`,
+			input:  "group1/project1/wso/a/b/c",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"group1", "project1"}},
+				{index: 2, segments: []string{"wso", "a"}, member: "wso/+{0,1}"},
+				{index: 4, segments: []string{"b"}},
+				{index: 5, segments: []string{"c"}},
+			},
+			captures: map[string]string{},
+		},
+		{
+			name:   "CandidateValue",
+			schema: `$gitlab_path.maybe_strip_last_prefix("helm-")/$[roles]`,
+			input:  "group1/helm-project1/admin",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"group1", "helm-project1"}, member: "group1/helm-project1"},
+				{index: 2, segments: []string{"admin"}, member: "admin"},
+			},
+			captures: map[string]string{},
+		},
+		{
+			name:   "EmptyWildcardStep",
+			schema: `a/*/b`,
+			input:  "a/b",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"a"}},
+				{index: 1, segments: []string{}},
+				{index: 1, segments: []string{"b"}},
+			},
+			captures: map[string]string{},
+		},
+		{
+			name:   "NamedCaptures",
+			schema: `secret/*:path/$[technologies]:tech/+:role`,
+			input:  "secret/team/app/postgres/admin",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"secret"}},
+				{index: 1, segments: []string{"team", "app"}},
+				{index: 3, segments: []string{"postgres"}, member: "postgres"},
+				{index: 4, segments: []string{"admin"}},
+			},
+			captures: map[string]string{
+				"path": "team/app",
+				"tech": "postgres",
+				"role": "admin",
+			},
+		},
+		{
+			name:   "CapturesAfterBacktracking",
+			schema: `+{1,3}:first/+{1,3}:second/end`,
+			input:  "a/b/c/end",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"a", "b"}},
+				{index: 2, segments: []string{"c"}},
+				{index: 3, segments: []string{"end"}},
+			},
+			captures: map[string]string{
+				"first":  "a/b",
+				"second": "c",
+			},
+		},
+		{
+			name:   "CapturesInsideCompositeSegment",
+			schema: `$[technologies]:{tech}_$[roles]:role`,
+			input:  "mssql_reader",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"mssql_reader"}},
+			},
+			captures: map[string]string{
+				"tech": "mssql",
+				"role": "reader",
+			},
+		},
+		{
+			name:   "CapturesInsideCompositeSegmentAfterBacktracking",
+			schema: `#[a-z_]+#:{prefix}_$[roles]:role/+`,
+			input:  "my_app_admin/x",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"my_app_admin"}},
+				{index: 1, segments: []string{"x"}},
+			},
+			captures: map[string]string{
+				"prefix": "my_app",
+				"role":   "admin",
+			},
+		},
+		{
+			name:   "GroupBranchAndCaptures",
+			schema: `(apps|infra/+:team):kind/$[technologies]`,
+			input:  "infra/core/postgres",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"infra", "core"}, member: "infra/+:team"},
+				{index: 2, segments: []string{"postgres"}, member: "postgres"},
+			},
+			captures: map[string]string{
+				"team": "core",
+				"kind": "infra/core",
+			},
+		},
+		{
+			name:   "OptionalGroupPresent",
+			schema: `deployment/(data)?:kv/+`,
+			input:  "deployment/data/x",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"deployment"}},
+				{index: 1, segments: []string{"data"}, member: "data"},
+				{index: 2, segments: []string{"x"}},
+			},
+			captures: map[string]string{"kv": "data"},
+		},
+		{
+			name:   "OptionalGroupOmitted",
+			schema: `deployment/(data)?:kv/+`,
+			input:  "deployment/x",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"deployment"}},
+				{index: 1, segments: []string{}},
+				{index: 1, segments: []string{"x"}},
+			},
+			captures: map[string]string{"kv": ""},
+		},
+		{
+			// Both forms match, the schema prefers the group
+			name:   "OptionalGroupCanonicalPresent",
+			schema: `deployment/(data)?/*`,
+			input:  "deployment/data/x",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"deployment"}},
+				{index: 1, segments: []string{"data"}, member: "data"},
+				{index: 2, segments: []string{"x"}},
+			},
+			captures: map[string]string{},
+		},
+		{
+			// Both forms match, the schema prefers leaving the group out
+			name:   "OptionalGroupCanonicalOmitted",
+			schema: `deployment/(data)??/*`,
+			input:  "deployment/data/x",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"deployment"}},
+				{index: 1, segments: []string{}},
+				{index: 1, segments: []string{"data", "x"}},
+			},
+			captures: map[string]string{},
+		},
+		{
+			name:   "NegationCaptures",
+			schema: `!$[roles]:name/+!$[technologies]:tech`,
+			input:  "writer/redis",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"writer"}},
+				{index: 1, segments: []string{"redis"}},
+			},
+			captures: map[string]string{
+				"name": "writer",
+				"tech": "redis",
+			},
+		},
+		{
+			name:   "ConditionBranch",
+			schema: `+:env/(?env=prod:$[roles]|+)`,
+			input:  "prod/admin",
+			steps: []expectedStep{
+				{index: 0, segments: []string{"prod"}},
+				{index: 1, segments: []string{"admin"}, member: "$[roles]"},
+			},
+			captures: map[string]string{"env": "prod"},
+		},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestMatchNormalisations(t *testing.T) {
+	options := SchemaOptions{Validation: ValidationOptions{
+		EmptySegments:     CollapseEmptySegments,
+		LeadingSeparator:  IgnoreSeparator,
+		TrailingSeparator: IgnoreSeparator,
+	}}
+	compiled, err := CreateSchemaWithOptions(`secret/+:name`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+
+	result, err := compiled.Match("/secret//db", &ValidationContext{})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	expected := []Normalisation{NormalisedLeadingSeparator, NormalisedEmptySegments}
+	if !slices.Equal(result.Normalisations, expected) || !slices.Equal(result.Segments, []string{"secret", "db"}) {
+		t.Fatalf("Expected segments secret/db after %v, got %v after %v", expected, result.Segments, result.Normalisations)
+	}
+	if step := result.Steps[1]; step.Index != 1 {
+		t.Fatalf("Expected the wildcard to consume the second remaining segment, got index %d", step.Index)
+	}
+	if result.Input != "/secret//db" || result.NormalisedInput != "secret/db" {
+		t.Fatalf("Expected the input /secret//db normalised to secret/db, got %s and %s", result.Input, result.NormalisedInput)
+	}
+
+	options.Validation.PercentDecode = true
+	compiled, err = CreateSchemaWithOptions(`secret/+:name`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	result, err = compiled.Match("secret/db%20admin/", &ValidationContext{})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	expected = []Normalisation{NormalisedTrailingSeparator, NormalisedPercentDecoding}
+	if !slices.Equal(result.Normalisations, expected) {
+		t.Fatalf("Expected %v, got %v", expected, result.Normalisations)
+	}
+	if name, _ := result.Capture("name"); name != "db admin" {
+		t.Fatalf("Expected the decoded segment to be captured, got %s", name)
+	}
+
+	result, err = compiled.Match("secret/db", &ValidationContext{})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if len(result.Normalisations) != 0 {
+		t.Fatalf("Expected no normalisations, got %v", result.Normalisations)
+	}
+}
+
+func TestInputPipeline(t *testing.T) {
+	options := SchemaOptions{Validation: ValidationOptions{
+		TrailingSeparator: IgnoreSeparator,
+		Pipeline:          []InputStep{StripMountPrefix("kv/team1"), StripKVv2Segment(), PercentDecodeInput(), LowerCaseInput()},
+	}}
+	compiled, err := CreateSchemaWithOptions(`(apps|infra)/+:name`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+
+	cases := []struct {
+		input          string
+		normalised     string
+		normalisations []Normalisation
+	}{
+		{"kv/team1/data/Apps/DB%20Admin/", "apps/db admin", []Normalisation{NormalisedTrailingSeparator, NormalisedMountPrefix, NormalisedKVv2, NormalisedPercentDecoding, NormalisedLowerCase}},
+		{"kv/team1/metadata/infra/vault", "infra/vault", []Normalisation{NormalisedMountPrefix, NormalisedKVv2}},
+		{"data/apps/db", "apps/db", []Normalisation{NormalisedKVv2}},
+		{"apps/db", "apps/db", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := compiled.Match(tc.input, &ValidationContext{})
+			if err != nil {
+				t.Fatalf("Match failed: %v", err)
+			}
+			if result.Input != tc.input || result.NormalisedInput != tc.normalised {
+				t.Fatalf("Expected the input %s normalised to %s, got %s and %s", tc.input, tc.normalised, result.Input, result.NormalisedInput)
+			}
+			if !slices.Equal(result.Normalisations, tc.normalisations) {
+				t.Fatalf("Expected %v, got %v", tc.normalisations, result.Normalisations)
+			}
+		})
+	}
+
+	// The mount is only stripped as a whole, and errors point into the raw input
+	if err := compiled.Validate("kv/apps/db", &ValidationContext{}); err == nil {
+		t.Fatalf("Expected a partial mount to be kept")
+	}
+	err = compiled.Validate("kv/team1/data/apps/db%zz", &ValidationContext{})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Reason != ReasonNormalisationFailed || validationErr.Index != 1 || validationErr.offset != 19 {
+		t.Fatalf("Expected a normalisation failure at the last segment, got %v", err)
+	}
+}
+
+func TestCaptureSeparators(t *testing.T) {
+	dotted, err := CreateSchemaWithOptions(`+{2}:{name}/!(a/c)`, SchemaOptions{Separator: "."})
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	result, err := dotted.Match("a.b.x", &ValidationContext{})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if name, _ := result.Capture("name"); name != "a.b" {
+		t.Fatalf("Expected name 'a.b', got '%s'", name)
+	}
+
+	// The capture spans both separators of the input
+	mixed, err := CreateSchema(`(+/+:+):{ref}/(latest|v:+)`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	result, err = mixed.Match("org/app:v1/latest", &ValidationContext{})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if ref, _ := result.Capture("ref"); ref != "org/app:v1" {
+		t.Fatalf("Expected ref 'org/app:v1', got '%s'", ref)
+	}
+
+	excluding, err := CreateSchema(`x:*!(a/#^b$#)`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	err = excluding.Validate("x:a/b", &ValidationContext{})
+	if err == nil || !strings.Contains(err.Error(), "'a/b' is excluded") {
+		t.Fatalf("Expected a/b to be excluded, got %v", err)
+	}
+
+	// A wildcard doesn't consume across a separator which the schema puts elsewhere
+	if err := excluding.Validate("x:a:c", &ValidationContext{}); err == nil {
+		t.Fatal("Expected x:a:c to be rejected")
+	}
+}
@@ -1,8 +1,9 @@
 package schema
 
 import (
-	"fmt"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/hydridity/Schematic/pkg/parser"
 )
@@ -12,25 +13,87 @@ type VariableStore interface {
 	GetVariableSet(name string) ([]string, bool)
 }
 
-// VariableModifierFunction represents a Modifier. It accepts a context variable value, split by '/', along with
-// a set of schema-provided arguments, and should modify the variable however it wants.
-// It should return the modified variable slice.
+// VariableModifierFunction represents a Modifier. It accepts a context variable value, split by the separator of the
+// schema, along with a set of schema-provided arguments, and should modify the variable however it wants.
+// It should return the modified variable slice. Arguments are passed as text, and the items of a list as separate
+// arguments; see ModifierRegistry for modifiers with typed arguments.
 type VariableModifierFunction func(variable []string, args []string) ([]string, error)
 
 type ValidationContext struct {
 	VariableStore     VariableStore
 	VariableModifiers map[string]VariableModifierFunction
+	// Trace records every matching step when set, see Explain. The trace is overwritten by every validation, so the
+	// context must not be shared between goroutines while it's set.
+	Trace *Trace
+
+	state *matchState
 }
 
 type Schema interface {
 	Validate(input string, context *ValidationContext) error
-	consume(inputSegments []string, context *ValidationContext) ([]string, error)
+	// Match validates the input like Validate does and reports which part of the input each constraint consumed.
+	Match(input string, context *ValidationContext) (*MatchResult, error)
+	match(inputSegments []string, context *ValidationContext, next Continuation) error
 	String() string
 }
 
+// SchemaOptions configures how a schema splits and normalises its input.
+type SchemaOptions struct {
+	// Separator delimits the segments of the input and of variable values, "/" when empty. A '/' in the schema
+	// always stands for this separator, while a ':' stands for itself, e.g. $[registry]/+/+:$[tags].
+	Separator string
+	// MaxLength is the maximum number of characters of the input, and MaxDepth the maximum number of its segments.
+	// Zero means there is no limit.
+	MaxLength int
+	MaxDepth  int
+	// CaseInsensitive compares literals, variable values, variable set members, backreferences and conditions with
+	// the input ignoring case, as the ~i flag does for a single piece. Regexes and typed segments are unaffected.
+	CaseInsensitive bool
+	// Modifiers holds the modifiers the schema may call, the predefined ones when nil. CreateSchema checks the calls
+	// of these modifiers against their signatures; other modifiers must be in ValidationContext.VariableModifiers.
+	Modifiers *ModifierRegistry
+	// Validation decides how separators at either end of the input and empty segments are handled, strictly by
+	// default.
+	Validation ValidationOptions
+	// MaxSteps bounds the work of matching an input, counted in the sequences of constraints tried while
+	// backtracking. A match which runs out of steps fails with ReasonTooComplex. DefaultMaxSteps applies when zero,
+	// and a negative value means there is no limit.
+	MaxSteps int
+}
+
+// DefaultMaxSteps is the number of steps a match may take unless SchemaOptions.MaxSteps says otherwise. Matching
+// the schemas in this package's tests takes a few hundred steps at most.
+const DefaultMaxSteps = 100_000
+
+func (o SchemaOptions) maxSteps() int {
+	switch {
+	case o.MaxSteps == 0:
+		return DefaultMaxSteps
+	case o.MaxSteps < 0:
+		return 0
+	}
+	return o.MaxSteps
+}
+
+func (o SchemaOptions) separator() string {
+	if o.Separator == "" {
+		return "/"
+	}
+	return o.Separator
+}
+
 type Impl struct {
 	Constraints []Constraint
-	ast         *parser.SchemaAST
+	options     SchemaOptions
+	// separators holds the separator in front of each constraint, empty for the first one.
+	separators []string
+	// inputSeparators are the distinct separators the input is split at, the default one first.
+	inputSeparators []string
+	ast             *parser.SchemaAST
+	// backtracks is set when the schema can backtrack over its own constraints, and referencesCaptures when any part
+	// of it refers to a capture, see matchSequence.
+	backtracks         bool
+	referencesCaptures bool
 }
 
 func (s *Impl) String() string {
@@ -46,51 +109,299 @@ func (s *Impl) String() string {
 }
 
 func (s *Impl) Validate(input string, context *ValidationContext) error {
-	inputSegments := strings.Split(strings.Trim(input, "/"), "/")
+	return s.validate(input, context, &matchState{})
+}
 
-	remainingSegments, err := s.consume(inputSegments, context)
+func (s *Impl) Match(input string, context *ValidationContext) (*MatchResult, error) {
+	state := &matchState{record: true}
+	err := s.validate(input, context, state)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return state.result(input), nil
+}
 
-	if len(remainingSegments) > 0 {
-		return fmt.Errorf("input '%s' did not fully consume all segments, remaining: %v", input, inputSegments)
+// validate matches the whole input, tracking its progress in the state.
+func (s *Impl) validate(input string, context *ValidationContext, state *matchState) error {
+	if context.Trace != nil {
+		state.trace = context.Trace
+		*state.trace = Trace{Input: input}
+	}
+	err := s.matchInput(input, context, state)
+	if state.trace != nil && err != nil {
+		state.trace.Error = err.Error()
 	}
-	return nil
+	return err
 }
 
-func (s *Impl) consume(inputSegments []string, context *ValidationContext) ([]string, error) {
-	mergedModifiers := getPredefinedModifiers()
-	for k, v := range context.VariableModifiers {
-		mergedModifiers[k] = v
+// matchInput normalises the input and matches its segments against the schema.
+func (s *Impl) matchInput(input string, context *ValidationContext, state *matchState) error {
+	path := splitInput(input, s.inputSeparators)
+	normalisations, err := s.options.Validation.normalise(input, &path, s.options.separator())
+	if err != nil {
+		return err
 	}
+	state.segments = path.segments
+	state.separator = s.options.separator()
+	state.delimiters = path.delimiters
+	state.normalisations = normalisations
+	state.maxSteps = s.options.maxSteps()
 
-	mergedContext := ValidationContext{
+	// The context of the match lives in the state, which saves an allocation
+	state.context = ValidationContext{
 		VariableStore:     context.VariableStore,
-		VariableModifiers: mergedModifiers,
+		VariableModifiers: context.VariableModifiers,
+		state:             state,
 	}
 
-	for _, constraint := range s.Constraints {
-		var err error
-		inputSegments, err = constraint.Consume(inputSegments, &mergedContext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to consume input '%s' with constraint %w", constraint.String(), err)
-		}
+	err = s.options.checkPathLimits(input, &path)
+	if err == nil {
+		err = s.match(path.segments, &state.context, func(rest []string) error {
+			if len(rest) > 0 {
+				return newValidationError(nil, rest, ReasonTrailingSegments, nil, "input did not fully consume all segments, remaining: %v", rest)
+			}
+			return nil
+		})
 	}
-	return inputSegments, nil
+
+	// A match which ran out of steps may have taken a failure for a success, e.g. of a negation
+	if state.exhausted != nil {
+		err = state.exhausted
+	}
+
+	// Failures are never wrapped while matching, and a type assertion doesn't allocate unlike errors.As
+	if validationErr, ok := err.(*ValidationError); ok {
+		validationErr.Input = input
+		validationErr.Index = len(path.segments) - validationErr.remaining
+		validationErr.offset = path.offsets[validationErr.Index]
+		validationErr.raw = path.raw(input, validationErr.Index)
+	}
+	return err
+}
+
+func (s *Impl) match(inputSegments []string, context *ValidationContext, next Continuation) error {
+	return matchSequence(s.Constraints, s.separators, nil, inputSegments, context.state.newFrame(s), context, next)
 }
 
+// newParser builds the schema parser once, it's safe for concurrent use.
+var newParser = sync.OnceValues(parser.NewParser)
+
 func CreateSchema(schemaStr string) (Schema, error) {
-	parserObj, err := parser.NewParser()
+	return CreateSchemaWithOptions(schemaStr, SchemaOptions{})
+}
+
+func CreateSchemaWithOptions(schemaStr string, options SchemaOptions) (Schema, error) {
+	parserObj, err := newParser()
 	if err != nil {
 		return nil, err
 	}
 
 	schemaAst, err := parserObj.ParseString("", schemaStr)
+	if err != nil {
+		return nil, newSchemaError(schemaStr, err)
+	}
+
+	separateBareCaptures(schemaAst, options)
+	if findings := lintErrors(schemaAst, options); len(findings) > 0 {
+		return nil, &SchemaError{
+			Schema:  schemaStr,
+			Line:    findings[0].Line,
+			Column:  findings[0].Column,
+			Length:  1,
+			Message: findings[0].Message,
+		}
+	}
+
+	compiled, err := compileSchema(schemaAst, options)
+	if err != nil {
+		return nil, err
+	}
+	compiled.inputSeparators = collectSeparators(schemaAst, options, []string{options.separator()})
+	return compiled, nil
+}
+
+func compileSchema(schemaAst *parser.SchemaAST, options SchemaOptions) (*Impl, error) {
+	constraints, err := compileConstraints(schemaAst, options)
 	if err != nil {
 		return nil, err
 	}
 
-	constraints := CompileConstraints(schemaAst)
-	return &Impl{Constraints: constraints, ast: schemaAst}, nil
+	// Resolve the separator in front of every part of the schema
+	separators := make([]string, 0, len(schemaAst.Parts))
+	for _, part := range schemaAst.Parts {
+		separators = append(separators, resolveSeparator(part.Separator, options))
+	}
+	return &Impl{Constraints: constraints, options: options, separators: separators, ast: schemaAst, backtracks: backtracks(constraints), referencesCaptures: referencesCaptures(schemaAst)}, nil
+}
+
+// referencesCaptures reports whether any part of the schema, including its groups, negations and conditions, refers
+// to a capture.
+func referencesCaptures(schemaAst *parser.SchemaAST) bool {
+	for _, part := range schemaAst.Parts {
+		if part.Condition != nil {
+			return true
+		}
+		for _, piece := range part.Pieces {
+			if piece.Backref != nil || piece.Regex != nil && captureReference.MatchString(*piece.Regex) {
+				return true
+			}
+		}
+		groups := []*parser.Group{part.Group}
+		negations := []*parser.Negation{part.Negation}
+		if part.Wildcard != nil {
+			negations = append(negations, part.Wildcard.Exclusion)
+		}
+		for _, negation := range negations {
+			if negation == nil {
+				continue
+			}
+			if negation.Backref != nil || negation.Regex != nil && captureReference.MatchString(*negation.Regex) {
+				return true
+			}
+			groups = append(groups, negation.Group)
+		}
+		for _, group := range groups {
+			if group == nil {
+				continue
+			}
+			for _, branch := range group.Branches {
+				if referencesCaptures(branch) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func resolveSeparator(separator string, options SchemaOptions) string {
+	if separator == "/" {
+		return options.separator()
+	}
+	return separator
+}
+
+// collectSeparators adds the distinct separators used by the schema, including the branches of its groups,
+// negated groups and conditions, to the list.
+func collectSeparators(schemaAst *parser.SchemaAST, options SchemaOptions, separators []string) []string {
+	for _, part := range schemaAst.Parts {
+		separator := resolveSeparator(part.Separator, options)
+		if separator != "" && !slices.Contains(separators, separator) {
+			separators = append(separators, separator)
+		}
+		groups := []*parser.Group{part.Group}
+		if part.Negation != nil {
+			groups = append(groups, part.Negation.Group)
+		}
+		if part.Wildcard != nil && part.Wildcard.Exclusion != nil {
+			groups = append(groups, part.Wildcard.Exclusion.Group)
+		}
+		for _, group := range groups {
+			if group == nil {
+				continue
+			}
+			for _, branch := range group.Branches {
+				separators = collectSeparators(branch, options, separators)
+			}
+		}
+		if part.Condition != nil {
+			separators = collectSeparators(part.Condition.Then, options, separators)
+			if part.Condition.Else != nil {
+				separators = collectSeparators(part.Condition.Else, options, separators)
+			}
+		}
+	}
+	return separators
+}
+
+// usesColonSeparator reports whether ':' separates segments of the schema, either written in the schema or as the
+// separator of the options.
+func usesColonSeparator(schemaAst *parser.SchemaAST, options SchemaOptions) bool {
+	return slices.Contains(collectSeparators(schemaAst, options, []string{options.separator()}), ":")
+}
+
+// separateBareCaptures reads every capture without braces as a ':' separator followed by a literal when ':'
+// separates segments of the schema, so that arn:aws:+:+ with ':' as the separator starts with two literals.
+// Captures in such a schema have to be written with braces, e.g. +:{name}.
+func separateBareCaptures(schemaAst *parser.SchemaAST, options SchemaOptions) {
+	if usesColonSeparator(schemaAst, options) {
+		splitBareCaptures(schemaAst)
+	}
+}
+
+func splitBareCaptures(schemaAst *parser.SchemaAST) {
+	parts := make([]*parser.Part, 0, len(schemaAst.Parts))
+	// literal is the part last added for a capture, which takes the pieces written right after it, e.g. +:v-${env}
+	var literal *parser.Part
+	for _, part := range schemaAst.Parts {
+		if literal != nil && part.Separator == "" && part.Pieces != nil {
+			literal.Pieces = append(literal.Pieces, part.Pieces...)
+			parts = parts[:len(parts)-1]
+			part = literal
+		}
+		literal = nil
+
+		// The captures which may follow the part, in the order they are written
+		var groups []*parser.Group
+		var captures []**parser.Capture
+		switch {
+		case part.Wildcard != nil:
+			if exclusion := part.Wildcard.Exclusion; exclusion != nil {
+				groups = append(groups, exclusion.Group)
+				captures = append(captures, &exclusion.Capture)
+			}
+			captures = append(captures, &part.Wildcard.Capture)
+		case part.Group != nil:
+			groups = append(groups, part.Group)
+		case part.Negation != nil:
+			groups = append(groups, part.Negation.Group)
+			captures = append(captures, &part.Negation.Capture)
+		case part.Condition != nil:
+			splitBareCaptures(part.Condition.Then)
+			if part.Condition.Else != nil {
+				splitBareCaptures(part.Condition.Else)
+			}
+		}
+		for _, group := range groups {
+			if group == nil {
+				continue
+			}
+			captures = append([]**parser.Capture{&group.Capture}, captures...)
+			for _, branch := range group.Branches {
+				splitBareCaptures(branch)
+			}
+		}
+
+		// A piece followed by a literal starts a new part, which takes the pieces after it, e.g. $[technologies]:admin
+		pieces := part.Pieces
+		part.Pieces = nil
+		for _, piece := range pieces {
+			part.Pieces = append(part.Pieces, piece)
+			if next := bareCapture(&piece.Capture); next != nil {
+				parts = append(parts, part)
+				part = next
+			}
+		}
+		parts = append(parts, part)
+		for _, capture := range captures {
+			if literal = bareCapture(capture); literal != nil {
+				parts = append(parts, literal)
+			}
+		}
+	}
+	schemaAst.Parts = parts
+}
+
+// bareCapture removes a capture without braces, returning the part it stands for: a ':' separator followed by the
+// name as a literal.
+func bareCapture(capture **parser.Capture) *parser.Part {
+	if *capture == nil || (*capture).Braced {
+		return nil
+	}
+	pos, name := (*capture).Pos, (*capture).Name
+	*capture = nil
+	literalPos := pos
+	literalPos.Offset++
+	literalPos.Column++
+	return &parser.Part{Pos: pos, Separator: ":", Pieces: []*parser.Piece{{Pos: literalPos, Literal: &name}}}
 }
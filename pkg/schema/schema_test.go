@@ -0,0 +1,1047 @@
+package schema
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mapVariableStore struct {
+	variables map[string]string
+	sets      map[string][]string
+}
+
+func (vs *mapVariableStore) GetVariable(name string) (string, bool) {
+	value, found := vs.variables[name]
+	return value, found
+}
+
+func (vs *mapVariableStore) GetVariableSet(name string) ([]string, bool) {
+	value, found := vs.sets[name]
+	return value, found
+}
+
+type schemaTestCase struct {
+	name       string
+	schema     string
+	input      string
+	shouldFail bool
+}
+
+func (tc *schemaTestCase) test(store VariableStore, t *testing.T) {
+	tc.testWith(SchemaOptions{}, store, nil, t)
+}
+
+func (tc *schemaTestCase) testWithModifiers(store VariableStore, modifiers map[string]VariableModifierFunction, t *testing.T) {
+	tc.testWith(SchemaOptions{}, store, modifiers, t)
+}
+
+func (tc *schemaTestCase) testWithOptions(options SchemaOptions, t *testing.T) {
+	tc.testWith(options, nil, nil, t)
+}
+
+func (tc *schemaTestCase) testWith(options SchemaOptions, store VariableStore, modifiers map[string]VariableModifierFunction, t *testing.T) {
+	t.Run(tc.name, func(t *testing.T) {
+		compiled, err := CreateSchemaWithOptions(tc.schema, options)
+		if err != nil {
+			t.Fatalf("Cannot create schema %s: %v", tc.schema, err)
+		}
+		err = compiled.Validate(tc.input, &ValidationContext{VariableStore: store, VariableModifiers: modifiers})
+		if !tc.shouldFail && err != nil {
+			t.Fatalf("Validation of %s against %s failed when it was expected to succeed: %v", tc.input, tc.schema, err)
+		}
+		if tc.shouldFail && err == nil {
+			t.Fatalf("Validation of %s against %s succeeded when it was expected to fail", tc.input, tc.schema)
+		}
+	})
+}
+
+func TestBacktracking(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"roles":        {"admin", "reader"},
+			"technologies": {"mssql", "wso/+{0,1}", "postgres/+"},
+			"prefixes":     {"a", "a/b"},
+		},
+	}
+	cases := []schemaTestCase{
+		{
+			name:   "MultiWildcardInTheMiddle",
+			schema: `secret/*/postgres/$[roles]`,
+			input:  "secret/a/b/c/postgres/admin",
+		},
+		{
+			name:   "MultiWildcardMatchingNothing",
+			schema: `secret/*/postgres/$[roles]`,
+			input:  "secret/postgres/reader",
+		},
+		{
+			name:   "MultiWildcardRepeatedLiteral",
+			schema: `secret/*/postgres/$[roles]`,
+			input:  "secret/postgres/postgres/admin",
+		},
+		{
+			name:       "MultiWildcardWrongRole",
+			schema:     `secret/*/postgres/$[roles]`,
+			input:      "secret/a/postgres/writer",
+			shouldFail: true,
+		},
+		{
+			name:       "MultiWildcardMissingLiteral",
+			schema:     `secret/*/postgres/$[roles]`,
+			input:      "secret/a/b/admin",
+			shouldFail: true,
+		},
+		{
+			name:   "QuantifiedWildcardGivesBack",
+			schema: `a/+{1,3}/b`,
+			input:  "a/x/y/b",
+		},
+		{
+			name:   "QuantifiedWildcardMinimum",
+			schema: `a/+{1,3}/b`,
+			input:  "a/x/b",
+		},
+		{
+			name:       "QuantifiedWildcardBelowMinimum",
+			schema:     `a/+{1,3}/b`,
+			input:      "a/b",
+			shouldFail: true,
+		},
+		{
+			name:       "QuantifiedWildcardAboveMaximum",
+			schema:     `a/+{1,3}/b`,
+			input:      "a/w/x/y/z/b",
+			shouldFail: true,
+		},
+		{
+			name:   "TwoQuantifiedWildcards",
+			schema: `+{0,2}/+{0,2}/end`,
+			input:  "a/b/c/d/end",
+		},
+		{
+			name:   "SetMemberWithOptionalWildcardFollowedByLiteral",
+			schema: `$[technologies]/admin`,
+			input:  "wso/admin",
+		},
+		{
+			name:   "SetMemberWithOptionalWildcardConsumingSegment",
+			schema: `$[technologies]/admin`,
+			input:  "wso/x/admin",
+		},
+		{
+			name:   "SetMemberWildcardGivesBack",
+			schema: `$[technologies]/+/$[roles]`,
+			input:  "wso/x/admin",
+		},
+		{
+			name:   "LaterSetMemberAfterEarlierMemberLeftWrongRest",
+			schema: `$[prefixes]/c`,
+			input:  "a/b/c",
+		},
+		{
+			name:   "VariableFollowedByWildcard",
+			schema: `$gitlab_path.strip_last_prefix("helm-")/*/$[roles]`,
+			input:  "group1/project1/a/b/admin",
+		},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+// countingConstraint counts the attempts to match the constraint it wraps.
+type countingConstraint struct {
+	Constraint
+	attempts *int
+}
+
+func (c countingConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	*c.attempts++
+	return c.Constraint.Match(path, context, next)
+}
+
+func TestBacktrackingWork(t *testing.T) {
+	compiled, err := CreateSchema(`*/a/*/a/*/a/*/a/*/b`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	impl := compiled.(*Impl)
+	attempts := 0
+	for i, constraint := range impl.Constraints {
+		impl.Constraints[i] = countingConstraint{Constraint: constraint, attempts: &attempts}
+	}
+
+	segments := slices.Repeat([]string{"a"}, 40)
+	if err := compiled.Validate(strings.Join(segments, "/"), &ValidationContext{}); err == nil {
+		t.Fatal("Expected the input without a trailing b to be rejected")
+	}
+	// Every constraint is tried at most once on every position of the input
+	if limit := len(impl.Constraints) * (len(segments) + 1); attempts > limit {
+		t.Fatalf("Expected at most %d attempts, got %d", limit, attempts)
+	}
+	if err := compiled.Validate(strings.Join(append(segments, "b"), "/"), &ValidationContext{}); err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+}
+
+func TestNestedBacktrackingWork(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{"deep": {"*/*/*/*/*/*/*/*/*/*/x"}},
+	}
+	segments := slices.Repeat([]string{"a"}, 60)
+	input := strings.Join(segments, "/")
+	for _, schemaStr := range []string{
+		`(*/*/*/*/*/*/*/*/*/*/x)`,
+		`$[deep]`,
+		`*:{c}/*/*/*/*/*/*/*/\c/x`,
+		`+:{env}/(?env=a:*/*/*/*/*/*/*/*/x|y)`,
+		`*:{c}/(*/*/*/*/*/*/*/x)/\c`,
+	} {
+		t.Run(schemaStr, func(t *testing.T) {
+			// Without a limit on the steps, only remembering the failures keeps the match from taking forever
+			compiled, err := CreateSchemaWithOptions(schemaStr, SchemaOptions{MaxSteps: -1})
+			if err != nil {
+				t.Fatalf("Cannot create schema: %v", err)
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- compiled.Validate(input, &ValidationContext{VariableStore: store})
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Fatal("Expected the input without an x to be rejected")
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Validation did not finish within 10s")
+			}
+		})
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	compiled, err := CreateSchemaWithOptions(`*/a/*/b`, SchemaOptions{MaxSteps: 10})
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	err = compiled.Validate("a/a/a/a/a/a/a/a/a/a/a/a", &ValidationContext{})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Reason != ReasonTooComplex || validationErr.Index != 0 {
+		t.Fatalf("Expected the match to give up, got %v", err)
+	}
+
+	compiled, err = CreateSchemaWithOptions(`*/a/*/b`, SchemaOptions{})
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	err = compiled.Validate("a/a/a/a/a/a/a/a/a/a/a/a", &ValidationContext{})
+	if !errors.As(err, &validationErr) || validationErr.Reason != ReasonLiteralMismatch {
+		t.Fatalf("Expected b to be missing, got %v", err)
+	}
+}
+
+func TestModifierChain(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "Group1/helm-ansible-Project1",
+		},
+	}
+	modifiers := map[string]VariableModifierFunction{
+		"lower": func(variable []string, args []string) ([]string, error) {
+			for i := range variable {
+				variable[i] = strings.ToLower(variable[i])
+			}
+			return variable, nil
+		},
+		"drop_head": func(variable []string, args []string) ([]string, error) {
+			return variable[1:], nil
+		},
+	}
+	cases := []schemaTestCase{
+		{
+			name:   "AllModifiersApplied",
+			schema: `$gitlab_path.strip_last_prefix("helm-").strip_last_prefix("ansible-").lower().drop_head()/admin`,
+			input:  "project1/admin",
+		},
+		{
+			name:   "ModifiersAppliedInOrder",
+			schema: `$gitlab_path.strip_last_prefix("ansible-").strip_last_prefix("helm-").lower()`,
+			input:  "group1/ansible-project1",
+		},
+		{
+			name:       "LastModifierMissing",
+			schema:     `$gitlab_path.strip_last_prefix("helm-").strip_last_prefix("ansible-").drop_head()`,
+			input:      "project1",
+			shouldFail: true,
+		},
+	}
+	for _, testCase := range cases {
+		testCase.testWithModifiers(store, modifiers, t)
+	}
+}
+
+func TestPredefinedModifiers(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "Group1/Sub_Group/helm-Project1",
+			"team":        "",
+		},
+	}
+	cases := []schemaTestCase{
+		{
+			name:   "Chain",
+			schema: `$gitlab_path.drop_first(1).strip_prefix("helm-", -1).replace("_", "-").lower()/admin`,
+			input:  "sub-group/project1/admin",
+		},
+		{
+			name:   "JoinedIntoComposite",
+			schema: `app-${gitlab_path}.take_first(2).join("-").lower()`,
+			input:  "app-group1-sub_group",
+		},
+		{
+			name:   "SingleSegment",
+			schema: `$gitlab_path.segment(-1).regex_replace("^helm-(.*)$", "$1")/+`,
+			input:  "Project1/db",
+		},
+		{
+			name:   "Default",
+			schema: `teams/$team.default("shared")`,
+			input:  "teams/shared",
+		},
+		{
+			name:       "FailingModifier",
+			schema:     `$gitlab_path.segment(3)`,
+			input:      "Group1",
+			shouldFail: true,
+		},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestCandidateModifiers(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "group1/helm-project1",
+			"project":     "group1/subgroup",
+		},
+	}
+	registry := DefaultModifierRegistry()
+	err := registry.Register(ModifierSpec{
+		Name: "ancestors",
+		Doc:  "Yields every leading part of the value, shortest first.",
+		Candidates: func(variable []string, args []ModifierArg) ([][]string, error) {
+			candidates := make([][]string, 0, len(variable))
+			for i := range variable {
+				candidates = append(candidates, slices.Clone(variable[:i+1]))
+			}
+			return candidates, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot register modifier: %v", err)
+	}
+
+	cases := []schemaTestCase{
+		{name: "Stripped", schema: `$gitlab_path.maybe_strip_last_prefix("helm-")/+`, input: "group1/project1/db"},
+		{name: "Unchanged", schema: `$gitlab_path.maybe_strip_last_prefix("helm-")/+`, input: "group1/helm-project1/db"},
+		{name: "Neither", schema: `$gitlab_path.maybe_strip_last_prefix("helm-")/+`, input: "group1/other/db", shouldFail: true},
+		{name: "FollowingModifiers", schema: `$gitlab_path.maybe_strip_last_prefix("helm-").upper(-1)`, input: "group1/HELM-PROJECT1"},
+		{name: "InComposite", schema: `x-${gitlab_path}.drop_first(1).maybe_strip_last_prefix("helm-")`, input: "x-project1"},
+		{name: "LaterCandidate", schema: `$project.ancestors()/c`, input: "group1/subgroup/c"},
+		// The first candidate matches, but the rest of the schema doesn't
+		{name: "Backtracking", schema: `$project.ancestors()/+`, input: "group1/subgroup/c"},
+		{name: "NoCandidate", schema: `$project.ancestors()`, input: "subgroup", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.testWith(SchemaOptions{Modifiers: registry}, store, nil, t)
+	}
+}
+
+func TestVariableSetModifiers(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"technologies": {"Postgres", "Kafka", "legacy"},
+			"projects":     {"helm-billing", "helm-auth", "group1/helm-db", "frontend"},
+		},
+	}
+	modifiers := map[string]VariableModifierFunction{
+		"suffix": func(variable []string, args []string) ([]string, error) {
+			variable[len(variable)-1] += args[0]
+			return variable, nil
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "Lower", schema: `$[technologies].lower()/+`, input: "postgres/db"},
+		{name: "LowerOriginal", schema: `$[technologies].lower()/+`, input: "Postgres/db", shouldFail: true},
+		{name: "StripLastPrefix", schema: `$[projects].strip_last_prefix("helm-")`, input: "billing"},
+		{name: "StripLastPrefixMultiSegment", schema: `$[projects].strip_last_prefix("helm-")`, input: "group1/db"},
+		{name: "Unprefixed", schema: `$[projects].strip_last_prefix("helm-")`, input: "frontend"},
+		{name: "CandidateMembers", schema: `$[projects].maybe_strip_last_prefix("helm-")`, input: "helm-auth"},
+		{name: "Without", schema: `$[technologies].without("legacy")`, input: "Kafka"},
+		{name: "WithoutRemoved", schema: `$[technologies].without("legacy")`, input: "legacy", shouldFail: true},
+		{name: "FilterAfterModifier", schema: `$[technologies].lower().without("kafka", "legacy")`, input: "kafka", shouldFail: true},
+		{name: "Only", schema: `$[technologies].only("Kafka")`, input: "Kafka"},
+		{name: "OnlyOthers", schema: `$[technologies].only("Kafka")`, input: "Postgres", shouldFail: true},
+		{name: "NothingLeft", schema: `$[technologies].only("mssql")`, input: "mssql", shouldFail: true},
+		{name: "ContextModifier", schema: `$[technologies].suffix("-db")`, input: "Kafka-db"},
+		{name: "Negated", schema: `!$[technologies].without("legacy")`, input: "legacy"},
+		{name: "CaseInsensitive", schema: `$[technologies].without("Kafka")~i`, input: "POSTGRES"},
+	}
+	for _, testCase := range cases {
+		testCase.testWithModifiers(store, modifiers, t)
+	}
+
+	// Set modifiers can't be applied to the value of a single variable
+	if _, err := CreateSchema(`$project.without("legacy")`); err == nil {
+		t.Fatalf("Expected CreateSchema to reject a set modifier on a variable")
+	}
+	if _, err := CreateSchema(`$[technologies].take_first("1")`); err == nil {
+		t.Fatalf("Expected CreateSchema to check the arguments of modifiers on sets")
+	}
+}
+
+func TestCompositeSegment(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"env":         "prod",
+			"gitlab_path": "group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"postgres", "kafka", "wso/+{0,1}"},
+		},
+	}
+	cases := []schemaTestCase{
+		{
+			name:   "VariableBetweenLiterals",
+			schema: `apps/app-${env}-db`,
+			input:  "apps/app-prod-db",
+		},
+		{
+			name:       "VariableBetweenLiteralsWrongValue",
+			schema:     `apps/app-${env}-db`,
+			input:      "apps/app-dev-db",
+			shouldFail: true,
+		},
+		{
+			name:       "VariableBetweenLiteralsMissingSuffix",
+			schema:     `apps/app-${env}-db`,
+			input:      "apps/app-prod",
+			shouldFail: true,
+		},
+		{
+			name:   "SetWithSuffix",
+			schema: `$[technologies]_admin`,
+			input:  "kafka_admin",
+		},
+		{
+			name:   "SetMemberWithOptionalWildcard",
+			schema: `$[technologies]_admin`,
+			input:  "wso_admin",
+		},
+		{
+			name:       "SetWithSuffixUnknownMember",
+			schema:     `$[technologies]_admin`,
+			input:      "mysql_admin",
+			shouldFail: true,
+		},
+		{
+			name:   "RegexWithSuffix",
+			schema: `#[0-9]+#-${env}`,
+			input:  "42-prod",
+		},
+		{
+			name:       "RegexMustCoverItsPiece",
+			schema:     `#[0-9]+#-${env}`,
+			input:      "v42-prod",
+			shouldFail: true,
+		},
+		{
+			name:   "ModifiedVariableWithPrefix",
+			schema: `x-${gitlab_path}.strip_last_prefix("helm-").drop_head()`,
+			input:  "x-project1",
+		},
+		{
+			name:       "MultiSegmentVariableInComposite",
+			schema:     `x-${gitlab_path}`,
+			input:      "x-group1/helm-project1",
+			shouldFail: true,
+		},
+		{
+			name:   "CompositeFollowedByWildcard",
+			schema: `${env}-$[technologies]/*`,
+			input:  "prod-postgres/a/b",
+		},
+	}
+	modifiers := map[string]VariableModifierFunction{
+		"drop_head": func(variable []string, args []string) ([]string, error) {
+			return variable[1:], nil
+		},
+	}
+	for _, testCase := range cases {
+		testCase.testWithModifiers(store, modifiers, t)
+	}
+}
+
+func BenchmarkValidate(b *testing.B) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "deployment/group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"mssql", "kafka", "wso/+{0,1}", "postgres/+"},
+		},
+	}
+	compiled, err := CreateSchema(`$gitlab_path.strip_last_prefix("helm-")/$[technologies]/#^[a-z]+$#`)
+	if err != nil {
+		b.Fatalf("Cannot create schema: %v", err)
+	}
+	context := &ValidationContext{VariableStore: store}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := compiled.Validate("deployment/group1/project1/postgres/main/admin", context); err != nil {
+			b.Fatalf("Validation failed: %v", err)
+		}
+	}
+}
+
+func TestVariableSetCacheInvalidation(t *testing.T) {
+	technologies := []string{"mssql", "postgres"}
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"technologies": technologies,
+		},
+	}
+	compiled, err := CreateSchema(`$[technologies]/+`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	context := &ValidationContext{VariableStore: store}
+
+	if err := compiled.Validate("postgres/admin", context); err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+	if err := compiled.Validate("kafka/admin", context); err == nil {
+		t.Fatalf("Validation succeeded for a technology which isn't in the set")
+	}
+
+	store.sets["technologies"] = append(technologies, "kafka")
+	if err := compiled.Validate("kafka/admin", context); err != nil {
+		t.Fatalf("Validation failed after the set changed: %v", err)
+	}
+
+	// Changing the set in place must be noticed as well
+	store.sets["technologies"][0] = "redis"
+	if err := compiled.Validate("redis/admin", context); err != nil {
+		t.Fatalf("Validation failed after the set changed in place: %v", err)
+	}
+	if err := compiled.Validate("mssql/admin", context); err == nil {
+		t.Fatalf("Validation succeeded for a technology which was removed from the set")
+	}
+}
+
+func TestValidateAllocations(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "deployment/group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"mssql", "kafka", "wso/+{0,1}", "postgres/+"},
+		},
+	}
+	compiled, err := CreateSchema(`$gitlab_path.strip_last_prefix("helm-")/$[technologies]/#^[a-z]+$#`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	context := &ValidationContext{VariableStore: store}
+
+	// Splitting the input and the variable, the match state and the failures of the members tried before postgres/+
+	// allocate, nothing else should
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := compiled.Validate("deployment/group1/project1/postgres/main/admin", context); err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+	})
+	if allocs > 15 {
+		t.Fatalf("Validate allocates %v times, expected at most 15", allocs)
+	}
+}
+
+func TestVariableSetCacheAlternatingStores(t *testing.T) {
+	first := &mapVariableStore{sets: map[string][]string{"technologies": {"mssql", "postgres"}}}
+	second := &mapVariableStore{sets: map[string][]string{"technologies": {"postgres", "kafka"}}}
+	compiled, err := CreateSchema(`$[technologies]/+`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	constraint := compiled.(*Impl).Constraints[0].(*VariableSetConstraint)
+
+	var postgres Schema
+	for i := 0; i < 3; i++ {
+		for _, store := range []*mapVariableStore{first, second} {
+			if err := compiled.Validate("postgres/admin", &ValidationContext{VariableStore: store}); err != nil {
+				t.Fatalf("Validation failed: %v", err)
+			}
+			schemas := constraint.members.Load().schemas
+			member := schemas[slices.Index(store.sets["technologies"], "postgres")]
+			if postgres == nil {
+				postgres = member
+			} else if member != postgres {
+				t.Fatalf("Member postgres was compiled again for another store")
+			}
+		}
+	}
+	if len(constraint.schemas) != 3 {
+		t.Fatalf("Expected 3 compiled members, got %d", len(constraint.schemas))
+	}
+}
+
+func TestInvalidRegexRejectedByCreateSchema(t *testing.T) {
+	if _, err := CreateSchema(`secret/#[a-z#/+`); err == nil {
+		t.Fatalf("Schema with an invalid regex was created")
+	}
+}
+
+func TestAlternation(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"technologies": {"mssql", "postgres"},
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "FirstBranch", schema: `(apps|infra/+)/$[technologies]`, input: "apps/postgres"},
+		{name: "MultiSegmentBranch", schema: `(apps|infra/+)/$[technologies]`, input: "infra/core/mssql"},
+		{name: "NoBranch", schema: `(apps|infra/+)/$[technologies]`, input: "tools/postgres", shouldFail: true},
+		{name: "BranchTooShort", schema: `(apps|infra/+)/$[technologies]`, input: "infra/postgres", shouldFail: true},
+		// The first branch matches, but only the second one lets the rest of the schema match
+		{name: "BacktrackIntoLaterBranch", schema: `(+|+/+)/end`, input: "a/b/end"},
+		{name: "BacktrackIntoWildcardInBranch", schema: `(a/*|b)/c/d`, input: "a/c/d/c/d"},
+		{name: "NestedGroups", schema: `((a|b)/c|d)/e`, input: "b/c/e"},
+		{name: "NestedGroupsFallback", schema: `((a|b)/c|d)/e`, input: "d/e"},
+		{name: "EmptyBranch", schema: `a/(*|x)/b`, input: "a/b"},
+		{name: "GroupWithSeparators", schema: `(docker.io/library|ghcr.io/+)/+:$[technologies]`, input: "ghcr.io/org/db:postgres"},
+		{name: "GroupWithWrongSeparator", schema: `(docker.io/library|ghcr.io/+)/+:$[technologies]`, input: "ghcr.io:org/db:postgres", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestOptionalGroups(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "group1/project1",
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "Present", schema: `deployment/(data)?/$gitlab_path/+`, input: "deployment/data/group1/project1/admin"},
+		{name: "Omitted", schema: `deployment/(data)?/$gitlab_path/+`, input: "deployment/group1/project1/admin"},
+		{name: "WrongSegment", schema: `deployment/(data)?/$gitlab_path/+`, input: "deployment/metadata/group1/project1/admin", shouldFail: true},
+		{name: "MultiSegmentGroup", schema: `a/(b/+)?/c`, input: "a/b/x/c"},
+		{name: "MultiSegmentGroupOmitted", schema: `a/(b/+)?/c`, input: "a/c"},
+		{name: "AtTheEnd", schema: `a/(b)?`, input: "a"},
+		{name: "PreferOmitted", schema: `a/(b)??/+`, input: "a/b/c"},
+		{name: "PreferOmittedFallsBack", schema: `a/(b)??/c`, input: "a/b/c"},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestNegation(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"reserved": {"root", "sys/+"},
+			"roles":    {"admin", "reader"},
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "NegatedLiteral", schema: `secret/!tmp`, input: "secret/app"},
+		{name: "NegatedLiteralRejected", schema: `secret/!tmp`, input: "secret/tmp", shouldFail: true},
+		{name: "NegatedLiteralSingleSegment", schema: `secret/!tmp`, input: "secret/a/b", shouldFail: true},
+		{name: "WildcardExcludingSet", schema: `secret/+!$[reserved]/$[roles]`, input: "secret/app/admin"},
+		{name: "WildcardExcludingSetRejected", schema: `secret/+!$[reserved]/$[roles]`, input: "secret/root/admin", shouldFail: true},
+		// The multi-segment member can't cover a single segment
+		{name: "WildcardExcludingMultiSegmentMember", schema: `secret/+!$[reserved]/+`, input: "secret/sys/x"},
+		{name: "MultiWildcardExcludingMultiSegmentMember", schema: `secret/*!$[reserved]/$[roles]`, input: "secret/sys/x/admin", shouldFail: true},
+		{name: "NegatedRegex", schema: `secret/!#^tmp-#`, input: "secret/app-tmp"},
+		{name: "NegatedRegexRejected", schema: `secret/!#^tmp-#`, input: "secret/tmp-app", shouldFail: true},
+		{name: "WildcardExcludingPath", schema: `secret/*!(*/admin/root/*)`, input: "secret/team/admin/app"},
+		{name: "WildcardExcludingPathRejected", schema: `secret/*!(*/admin/root/*)`, input: "secret/team/admin/root/app", shouldFail: true},
+		{name: "WildcardExcludingPathAtTheEnd", schema: `secret/*!(*/admin/root/*)`, input: "secret/admin/root", shouldFail: true},
+		// The excluded segment is consumed by the wildcard in front of it
+		{name: "BacktrackAroundExclusion", schema: `*/!root/+`, input: "root/a/b"},
+		{name: "NegatedGroup", schema: `secret/!(tmp|scratch)`, input: "secret/scratch", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestQuantifiers(t *testing.T) {
+	cases := []schemaTestCase{
+		{name: "Exact", schema: `teams/+{2}/app`, input: "teams/a/b/app"},
+		{name: "ExactTooFew", schema: `teams/+{2}/app`, input: "teams/a/app", shouldFail: true},
+		{name: "ExactTooMany", schema: `teams/+{2}/app`, input: "teams/a/b/c/app", shouldFail: true},
+		{name: "AtLeast", schema: `teams/+{2,}/app`, input: "teams/a/b/c/d/app"},
+		{name: "AtLeastTooFew", schema: `teams/+{2,}/app`, input: "teams/a/app", shouldFail: true},
+		{name: "AtMost", schema: `teams/*{,3}/app`, input: "teams/app"},
+		{name: "AtMostFull", schema: `teams/*{,3}/app`, input: "teams/a/b/c/app"},
+		{name: "AtMostTooMany", schema: `teams/*{,3}/app`, input: "teams/a/b/c/d/app", shouldFail: true},
+		{name: "StarWithMinimum", schema: `teams/*{1,}`, input: "teams", shouldFail: true},
+		// '+' keeps its minimum of one segment when the quantifier leaves it out
+		{name: "PlusAtMost", schema: `teams/+{,3}/app`, input: "teams/a/b/app"},
+		{name: "PlusAtMostTooFew", schema: `teams/+{,3}/app`, input: "teams/app", shouldFail: true},
+		{name: "PlusOpen", schema: `teams/+{,}`, input: "teams", shouldFail: true},
+		// The open wildcard gives segments back until the rest of the schema matches
+		{name: "OpenBacktracking", schema: `+{1,}/+{2}/end`, input: "a/b/c/d/end"},
+		{name: "OpenBacktrackingTooShort", schema: `+{1,}/+{2}/end`, input: "a/b/end", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(nil, t)
+	}
+}
+
+func TestBackreferences(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"technologies": {"mssql", "postgres"},
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "RepeatedSegment", schema: `teams/+:team/apps/+/owners/\team`, input: "teams/core/apps/api/owners/core"},
+		{name: "RepeatedSegmentMismatch", schema: `teams/+:team/apps/+/owners/\team`, input: "teams/core/apps/api/owners/infra", shouldFail: true},
+		{name: "RepeatedMultipleSegments", schema: `*:path/x/\path`, input: "a/b/x/a/b"},
+		{name: "RepeatedMultipleSegmentsMismatch", schema: `*:path/x/\path`, input: "a/b/x/a", shouldFail: true},
+		{name: "InsideComposite", schema: `+:project/\{project}-#[a-z]+#`, input: "billing/billing-db"},
+		{name: "InsideCompositeMismatch", schema: `+:project/\{project}-#[a-z]+#`, input: "billing/payments-db", shouldFail: true},
+		{name: "InsideRegex", schema: `+:project/#^\k<project>(-[0-9]+)?$#`, input: "a.b/a.b-2"},
+		// The captured text is matched literally, not as a pattern
+		{name: "InsideRegexQuoted", schema: `+:project/#^\k<project>(-[0-9]+)?$#`, input: "a.b/axb-2", shouldFail: true},
+		{name: "CapturedFromComposite", schema: `$[technologies]:{tech}_admin/\tech`, input: "mssql_admin/mssql"},
+		{name: "Negated", schema: `+:owner/!\owner`, input: "alice/bob"},
+		{name: "NegatedMismatch", schema: `+:owner/!\owner`, input: "alice/alice", shouldFail: true},
+		// The first split of the wildcards is given up because the reference doesn't match
+		{name: "Backtracking", schema: `+{1,2}:a/+{1,2}/\a`, input: "x/y/z/x"},
+		{name: "FromBranch", schema: `(+:name|x/+:name)/\name`, input: "x/y/y"},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	store := &mapVariableStore{
+		sets: map[string][]string{
+			"technologies":      {"mssql", "postgres", "redis"},
+			"prod_technologies": {"mssql", "postgres"},
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "Then", schema: `+:env/(?env=prod:$[prod_technologies]|$[technologies])`, input: "prod/postgres"},
+		{name: "ThenMismatch", schema: `+:env/(?env=prod:$[prod_technologies]|$[technologies])`, input: "prod/redis", shouldFail: true},
+		{name: "Else", schema: `+:env/(?env=prod:$[prod_technologies]|$[technologies])`, input: "dev/redis"},
+		{name: "ElseMismatch", schema: `+:env/(?env=prod:$[prod_technologies]|$[technologies])`, input: "dev/kafka", shouldFail: true},
+		{name: "Regex", schema: `+:env/(?env=#^prod-#:approved|+)/+`, input: "prod-eu/approved/db"},
+		{name: "RegexMismatch", schema: `+:env/(?env=#^prod-#:approved|+)/+`, input: "prod-eu/pending/db", shouldFail: true},
+		{name: "RegexElse", schema: `+:env/(?env=#^prod-#:approved|+)/+`, input: "dev/pending/db"},
+		// Without an else branch nothing is matched when the condition doesn't hold
+		{name: "WithoutElse", schema: `+:env/(?env=prod:audit/+)/+`, input: "prod/audit/2024/db"},
+		{name: "WithoutElseSkipped", schema: `+:env/(?env=prod:audit/+)/+`, input: "dev/db"},
+		{name: "WithoutElseMissing", schema: `+:env/(?env=prod:audit/+)/+`, input: "prod/db", shouldFail: true},
+		{name: "MultipleSegments", schema: `*:path/(?path=#^a/b$#:x|y)`, input: "a/b/x"},
+		{name: "MultipleSegmentsElse", schema: `*:path/(?path=#^a/b$#:x|y)`, input: "a/b/c/y"},
+		// The capture is retried with another split when the branch it chose fails
+		{name: "Backtracking", schema: `+{1,2}:env/(?env=#^a/b$#:x|+)`, input: "a/b/x"},
+		{name: "CaptureFromGroup", schema: `(dev|prod):env/(?env=prod:$[prod_technologies]|$[technologies])`, input: "prod/mssql"},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+}
+
+func TestTypedSegments(t *testing.T) {
+	cases := []schemaTestCase{
+		{name: "UUID", schema: `tenants/<uuid>/+`, input: "tenants/123e4567-e89b-12d3-a456-426614174000/db"},
+		{name: "UUIDMismatch", schema: `tenants/<uuid>/+`, input: "tenants/123e4567/db", shouldFail: true},
+		{name: "RotatedSecret", schema: `secrets/+/<date:2006-01>/v<semver>`, input: "secrets/db/2024-03/v1.2.0"},
+		{name: "RotatedSecretInvalidMonth", schema: `secrets/+/<date:2006-01>/v<semver>`, input: "secrets/db/2024-13/v1.2.0", shouldFail: true},
+		{name: "RotatedSecretMissingPrefix", schema: `secrets/+/<date:2006-01>/v<semver>`, input: "secrets/db/2024-03/1.2.0", shouldFail: true},
+		{name: "DateInComposite", schema: `backup-<date>.tar`, input: "backup-2024-02-29.tar"},
+		{name: "DateInCompositeInvalidDay", schema: `backup-<date>.tar`, input: "backup-2023-02-29.tar", shouldFail: true},
+		{name: "IntRange", schema: `shards/<int:1..99>`, input: "shards/42"},
+		{name: "IntRangeBelow", schema: `shards/<int:1..99>`, input: "shards/0", shouldFail: true},
+		{name: "IntInComposite", schema: `replica-<int>`, input: "replica-3"},
+		{name: "IntInCompositeNotANumber", schema: `replica-<int>`, input: "replica-x", shouldFail: true},
+		{name: "DNSLabel", schema: `namespaces/<dns_label>:ns`, input: "namespaces/helm-project1"},
+		{name: "DNSLabelUppercase", schema: `namespaces/<dns_label>:ns`, input: "namespaces/Helm", shouldFail: true},
+		{name: "NegatedType", schema: `tags/!<semver>`, input: "tags/latest"},
+		{name: "NegatedTypeMismatch", schema: `tags/!<semver>`, input: "tags/1.0.0", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(&mapVariableStore{}, t)
+	}
+}
+
+func TestSegmentLimits(t *testing.T) {
+	cases := []schemaTestCase{
+		{name: "Limits", schema: `secret/+<len 3..40, charset [a-z0-9-]>`, input: "secret/helm-project1"},
+		{name: "TooShort", schema: `secret/+<len 3..40, charset [a-z0-9-]>`, input: "secret/db", shouldFail: true},
+		{name: "OutsideCharset", schema: `secret/+<len 3..40, charset [a-z0-9-]>`, input: "secret/Helm_Project", shouldFail: true},
+		// Limits count characters, not bytes
+		{name: "Characters", schema: `+<len ..4>`, input: "äöüß"},
+		{name: "QuantifiedLimits", schema: `+{1,3}<charset [a-z]>/+`, input: "a/b/1"},
+		{name: "QuantifiedLimitsRejected", schema: `+{1,3}<charset [a-z]>/+`, input: "a/1/b", shouldFail: true},
+		{name: "MultiLimits", schema: `*<len ..3>/admin`, input: "abc/de/admin"},
+		{name: "MultiLimitsRejected", schema: `*<len ..3>/admin`, input: "abc/defg/admin", shouldFail: true},
+		{name: "EmptySegment", schema: `a/+/b`, input: "a//b", shouldFail: true},
+		{name: "EmptySegmentInMulti", schema: `a/*/b`, input: "a/x//b", shouldFail: true},
+		{name: "EmptySegmentInQuantified", schema: `a/+{0,2}/b`, input: "a//b", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(&mapVariableStore{}, t)
+	}
+}
+
+func TestPathLimits(t *testing.T) {
+	cases := []struct {
+		schemaTestCase
+		options SchemaOptions
+	}{
+		{schemaTestCase: schemaTestCase{name: "WithinLength", schema: `*`, input: "a/b/c"}, options: SchemaOptions{MaxLength: 5}},
+		{schemaTestCase: schemaTestCase{name: "TooLong", schema: `*`, input: "a/b/cd", shouldFail: true}, options: SchemaOptions{MaxLength: 5}},
+		{schemaTestCase: schemaTestCase{name: "WithinDepth", schema: `*`, input: "a/b/c"}, options: SchemaOptions{MaxDepth: 3}},
+		{schemaTestCase: schemaTestCase{name: "TooDeep", schema: `*`, input: "a/b/c/d", shouldFail: true}, options: SchemaOptions{MaxDepth: 3}},
+	}
+	for _, tc := range cases {
+		tc.testWithOptions(tc.options, t)
+	}
+}
+
+func TestValidationOptions(t *testing.T) {
+	collapse := SchemaOptions{Validation: ValidationOptions{EmptySegments: CollapseEmptySegments}}
+	allow := SchemaOptions{Validation: ValidationOptions{EmptySegments: AllowEmptySegments}}
+	require := SchemaOptions{Validation: ValidationOptions{LeadingSeparator: RequireSeparator, TrailingSeparator: RequireSeparator}}
+	ignore := SchemaOptions{Validation: ValidationOptions{LeadingSeparator: IgnoreSeparator, TrailingSeparator: IgnoreSeparator}}
+	decode := SchemaOptions{Validation: ValidationOptions{PercentDecode: true}}
+	nfc := SchemaOptions{Validation: ValidationOptions{NormaliseNFC: true}}
+	cases := []struct {
+		schemaTestCase
+		options SchemaOptions
+	}{
+		{schemaTestCase: schemaTestCase{name: "Strict", schema: `a/+`, input: "a/b"}},
+		{schemaTestCase: schemaTestCase{name: "StrictEmptySegment", schema: `a/*`, input: "a//b", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "StrictLeadingSeparator", schema: `a/+`, input: "/a/b", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "StrictTrailingSeparator", schema: `a/+`, input: "a/b/", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "StrictEmptyInput", schema: `*`, input: ""}},
+		{schemaTestCase: schemaTestCase{name: "Collapse", schema: `a/+`, input: "a//b"}, options: collapse},
+		{schemaTestCase: schemaTestCase{name: "CollapseKeepsSeparatorPolicy", schema: `a/+`, input: "a//b/", shouldFail: true}, options: collapse},
+		{schemaTestCase: schemaTestCase{name: "Allow", schema: `a/+/b`, input: "a//b"}, options: allow},
+		{schemaTestCase: schemaTestCase{name: "AllowedEmptySegmentIsMatched", schema: `a/b`, input: "a//b", shouldFail: true}, options: allow},
+		{schemaTestCase: schemaTestCase{name: "AllowedEmptySegmentAndLimits", schema: `a/+<len 1..>/b`, input: "a//b", shouldFail: true}, options: allow},
+		{schemaTestCase: schemaTestCase{name: "Require", schema: `a/+`, input: "/a/b/"}, options: require},
+		{schemaTestCase: schemaTestCase{name: "RequireMissingLeading", schema: `a/+`, input: "a/b/", shouldFail: true}, options: require},
+		{schemaTestCase: schemaTestCase{name: "RequireMissingTrailing", schema: `a/+`, input: "/a/b", shouldFail: true}, options: require},
+		// Only one separator is required, the next one makes an empty segment
+		{schemaTestCase: schemaTestCase{name: "RequireRepeated", schema: `a/+`, input: "//a/b/", shouldFail: true}, options: require},
+		{schemaTestCase: schemaTestCase{name: "Ignore", schema: `a/+`, input: "//a/b/"}, options: ignore},
+		{schemaTestCase: schemaTestCase{name: "IgnoreMissing", schema: `a/+`, input: "a/b"}, options: ignore},
+		{schemaTestCase: schemaTestCase{name: "IgnoreOnlySeparators", schema: `*`, input: "//"}, options: ignore},
+		{schemaTestCase: schemaTestCase{name: "CollapseMixedSeparators", schema: `+/+:+`, input: "a//b:c"}, options: collapse},
+		{schemaTestCase: schemaTestCase{name: "PercentEncoded", schema: `charts/#^helm chart$#`, input: "charts/helm%20chart", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "PercentDecode", schema: `charts/#^helm chart$#`, input: "charts/helm%20chart"}, options: decode},
+		// An encoded separator is decoded within its segment
+		{schemaTestCase: schemaTestCase{name: "PercentDecodeSeparator", schema: `charts/+`, input: "charts/a%2Fb"}, options: decode},
+		{schemaTestCase: schemaTestCase{name: "PercentDecodeInvalid", schema: `charts/+`, input: "charts/100%", shouldFail: true}, options: decode},
+		{schemaTestCase: schemaTestCase{name: "Decomposed", schema: "teams/#^caf\u00e9$#", input: "teams/cafe\u0301", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "NFC", schema: "teams/#^caf\u00e9$#", input: "teams/cafe\u0301"}, options: nfc},
+		{schemaTestCase: schemaTestCase{name: "NFCAfterDecoding", schema: "teams/#^caf\u00e9$#", input: "teams/cafe%CC%81"}, options: SchemaOptions{Validation: ValidationOptions{PercentDecode: true, NormaliseNFC: true}}},
+		{schemaTestCase: schemaTestCase{name: "OtherSeparator", schema: `a/+`, input: ".a.b"}, options: SchemaOptions{Separator: ".", Validation: ignore.Validation}},
+	}
+	for _, tc := range cases {
+		tc.testWithOptions(tc.options, t)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "Group1/Helm-Project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"mssql", "postgres/+"},
+		},
+	}
+	cases := []schemaTestCase{
+		{name: "Literal", schema: `secret~i/+`, input: "Secret/db"},
+		{name: "LiteralCaseSensitive", schema: `secret/+`, input: "Secret/db", shouldFail: true},
+		{name: "Variable", schema: `$gitlab_path~i/+`, input: "group1/helm-project1/db"},
+		{name: "VariableCaseSensitive", schema: `$gitlab_path/+`, input: "group1/helm-project1/db", shouldFail: true},
+		{name: "VariableSet", schema: `$[technologies]~i`, input: "Postgres/Admin"},
+		{name: "VariableSetCaseSensitive", schema: `$[technologies]`, input: "Postgres/admin", shouldFail: true},
+		{name: "Backreference", schema: `+:team/\team~i`, input: "core/CORE"},
+		{name: "InComposite", schema: `app-${gitlab_path}~i`, input: "app-group1", shouldFail: true},
+		{name: "NegatedLiteral", schema: `!tmp~i`, input: "TMP", shouldFail: true},
+		// The flag only applies to the piece it follows
+		{name: "OtherPiecesCaseSensitive", schema: `secret~i/data`, input: "Secret/Data", shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(store, t)
+	}
+
+	options := SchemaOptions{CaseInsensitive: true}
+	compiled, err := CreateSchemaWithOptions(`secret/$gitlab_path/$[technologies]/+:env/(?env=prod:#^[a-z]+$#|+)`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	err = compiled.Validate("SECRET/group1/helm-project1/MSSQL/Prod/x", &ValidationContext{VariableStore: store})
+	if err != nil {
+		t.Fatalf("Validation ignoring case failed: %v", err)
+	}
+	// Regexes are not affected by the schema option
+	err = compiled.Validate("SECRET/group1/helm-project1/MSSQL/Prod/X", &ValidationContext{VariableStore: store})
+	if err == nil {
+		t.Fatalf("Validation succeeded although the regex does not match")
+	}
+}
+
+func TestSeparators(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"env":         "prod",
+			"region_env":  "eu.prod",
+			"gitlab_path": "group1/project1",
+		},
+		sets: map[string][]string{
+			"registries": {"docker.io", "ghcr.io/org"},
+			"tags":       {"latest", "v1.2"},
+		},
+	}
+	cases := []struct {
+		schemaTestCase
+		separator string
+	}{
+		{schemaTestCase: schemaTestCase{name: "KafkaTopic", schema: `$env/orders/+`, input: "prod.orders.created"}, separator: "."},
+		{schemaTestCase: schemaTestCase{name: "KafkaTopicSlashes", schema: `$env/orders/+`, input: "prod/orders/created", shouldFail: true}, separator: "."},
+		{schemaTestCase: schemaTestCase{name: "Arn", schema: `arn/aws/#^(s3|sqs)$#/+`, input: "arn:aws:sqs:queue"}, separator: ":"},
+		{schemaTestCase: schemaTestCase{name: "ResourceName", schema: `app/$env/+`, input: "app-prod-db"}, separator: "-"},
+		{schemaTestCase: schemaTestCase{name: "VariableSplitBySeparator", schema: `$region_env/orders`, input: "eu.prod.orders"}, separator: "."},
+		{schemaTestCase: schemaTestCase{name: "DottedLiteral", schema: `docker.io/+`, input: "docker.io/library"}},
+		{schemaTestCase: schemaTestCase{name: "ImageReference", schema: `$[registries]/+/+:$[tags]`, input: "docker.io/team/app:latest"}},
+		{schemaTestCase: schemaTestCase{name: "ImageReferenceNestedRegistry", schema: `$[registries]/+:$[tags]`, input: "ghcr.io/org/app:v1.2"}},
+		{schemaTestCase: schemaTestCase{name: "ImageReferenceSwappedSeparators", schema: `$[registries]/+/+:$[tags]`, input: "docker.io/team:app/latest", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "ImageReferenceMissingTag", schema: `$[registries]/+/+:$[tags]`, input: "docker.io/team/app/latest", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "SeparatorAfterEmptyWildcard", schema: `a/*:#^b$#`, input: "a:b"}},
+		{schemaTestCase: schemaTestCase{name: "SeparatorAfterWildcard", schema: `a/*:#^b$#`, input: "a/x/y:b"}},
+		{schemaTestCase: schemaTestCase{name: "WrongSeparatorAfterWildcard", schema: `a/*:#^b$#`, input: "a/x/b", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "WildcardAcrossSeparators", schema: `$[registries]/*:$[tags]`, input: "docker.io/team/app:latest"}},
+		{schemaTestCase: schemaTestCase{name: "WildcardAcrossWrongSeparator", schema: `$[registries]/*:$[tags]`, input: "docker.io/team:app:latest", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "QuantifiedWildcardAcrossSeparators", schema: `$[registries]/+{2}:$[tags]`, input: "docker.io/team/app:latest"}},
+		{schemaTestCase: schemaTestCase{name: "QuantifiedWildcardAcrossWrongSeparator", schema: `$[registries]/+{2}:$[tags]`, input: "docker.io/team:app:latest", shouldFail: true}},
+		{schemaTestCase: schemaTestCase{name: "VariableAcrossSeparators", schema: `$gitlab_path:+`, input: "group1/project1:db"}},
+		{schemaTestCase: schemaTestCase{name: "VariableWithWrongSeparator", schema: `$gitlab_path:+`, input: "group1:project1:db", shouldFail: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiled, err := CreateSchemaWithOptions(tc.schema, SchemaOptions{Separator: tc.separator})
+			if err != nil {
+				t.Fatalf("Cannot create schema %s: %v", tc.schema, err)
+			}
+			err = compiled.Validate(tc.input, &ValidationContext{VariableStore: store})
+			if !tc.shouldFail && err != nil {
+				t.Fatalf("Validation of %s against %s failed when it was expected to succeed: %v", tc.input, tc.schema, err)
+			}
+			if tc.shouldFail && err == nil {
+				t.Fatalf("Validation of %s against %s succeeded when it was expected to fail", tc.input, tc.schema)
+			}
+		})
+	}
+
+	compiled, err := CreateSchema(`$[registries]/+:{name}:$[tags]:{tag}`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	result, err := compiled.Match("ghcr.io/org/app:v1.2", &ValidationContext{VariableStore: store})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if name, _ := result.Capture("name"); name != "app" {
+		t.Fatalf("Expected name 'app', got '%s'", name)
+	}
+	if tag, _ := result.Capture("tag"); tag != "v1.2" {
+		t.Fatalf("Expected tag 'v1.2', got '%s'", tag)
+	}
+}
+
+func TestSeparatorCaptures(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{"p": "team"},
+		sets: map[string][]string{
+			"registries": {"docker.io"},
+			"tags":       {"latest"},
+		},
+	}
+
+	// Without braces ':x' is a separator followed by a literal once ':' separates segments
+	for _, tc := range []struct {
+		schema    string
+		separator string
+		input     string
+	}{
+		{schema: `$[registries]/+:name:$[tags]`, input: "docker.io/app:name:latest"},
+		{schema: `+/+:latest:+`, input: "team/app:latest:x"},
+		{schema: `$p:x`, separator: ":", input: "team:x"},
+		{schema: `arn:aws:+:+`, separator: ":", input: "arn:aws:iam:role"},
+		{schema: `arn:aws:#^(s3|sqs)$#:+`, input: "arn:aws:sqs:queue"},
+		{schema: `+:{name}:$[tags]:admin`, input: "app:latest:admin"},
+		{schema: `$[registries]/+:v-${p}:$[tags]`, input: "docker.io/app:v-team:latest"},
+	} {
+		compiled, err := CreateSchemaWithOptions(tc.schema, SchemaOptions{Separator: tc.separator})
+		if err != nil {
+			t.Fatalf("Cannot create schema %s with separator '%s': %v", tc.schema, tc.separator, err)
+		}
+		if err := compiled.Validate(tc.input, &ValidationContext{VariableStore: store}); err != nil {
+			t.Fatalf("Expected %s to match %s: %v", tc.input, tc.schema, err)
+		}
+	}
+
+	arn, err := CreateSchemaWithOptions(`arn:aws:+:+`, SchemaOptions{Separator: ":"})
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	if err := arn.Validate("arn:gcp:iam:role", &ValidationContext{}); err == nil {
+		t.Fatal("Expected arn:gcp:iam:role to be rejected by arn:aws:+:+")
+	}
+
+	options := SchemaOptions{Separator: ":"}
+	captured, err := CreateSchemaWithOptions(`$p:{x}`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	result, err := captured.Match("team", &ValidationContext{VariableStore: store})
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if x, _ := result.Capture("x"); x != "team" {
+		t.Fatalf("Expected x 'team', got '%s'", x)
+	}
+	if err := captured.Validate("team:x", &ValidationContext{VariableStore: store}); err == nil {
+		t.Fatal("Expected team:x to be rejected by $p:{x}")
+	}
+
+	literal, err := CreateSchemaWithOptions(`$p:#^x$#`, options)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+	if err := literal.Validate("team:x", &ValidationContext{VariableStore: store}); err != nil {
+		t.Fatalf("Expected team:x to match $p:#^x$#: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TraceEvent is the kind of a TraceNode.
+type TraceEvent string
+
+const (
+	// TraceConsumed is recorded every time a constraint consumes part of the input, including the alternatives that
+	// were backtracked later.
+	TraceConsumed TraceEvent = "consumed"
+	// TraceRejected is recorded when a constraint, or the end of the schema, rejects the input.
+	TraceRejected TraceEvent = "rejected"
+	// TraceMember groups the steps of matching one member of a variable set.
+	TraceMember TraceEvent = "member"
+	// TraceVariable records how the modifiers transformed a variable value.
+	TraceVariable TraceEvent = "variable"
+	// TracePieces groups the steps of splitting a composite segment between its pieces.
+	TracePieces TraceEvent = "pieces"
+)
+
+// Trace records every step of matching an input against a schema, in the order they happened. Setting it on the
+// ValidationContext enables tracing, see also Explain. Each validation writes to the trace of its context, so a
+// context with a Trace must not be used by several validations at once.
+type Trace struct {
+	Input string       `json:"input"`
+	Nodes []*TraceNode `json:"nodes"`
+	Error string       `json:"error,omitempty"`
+
+	// scopes is the stack of nodes the next events belong to. A nil scope drops the events, e.g. while checking a
+	// negation.
+	scopes []*TraceNode
+}
+
+type TraceNode struct {
+	Event      TraceEvent `json:"event"`
+	Constraint string     `json:"constraint,omitempty"`
+	// Index of the input segment the event happened at.
+	Index    int      `json:"index"`
+	Segments []string `json:"segments,omitempty"`
+	Member   string   `json:"member,omitempty"`
+	// Backtracked is set on consumed events after which the rest of the schema did not match.
+	Backtracked bool            `json:"backtracked,omitempty"`
+	Variable    *TracedVariable `json:"variable,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Children    []*TraceNode    `json:"children,omitempty"`
+}
+
+// TracedVariable holds a raw variable value and the result of every modifier applied to it.
+type TracedVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Set is true for a variable set, whose members are separated by commas in the value and the results.
+	Set       bool             `json:"set,omitempty"`
+	Modifiers []TracedModifier `json:"modifiers,omitempty"`
+}
+
+type TracedModifier struct {
+	Name   string   `json:"name"`
+	Args   []string `json:"args,omitempty"`
+	Result []string `json:"result,omitempty"`
+	// Candidates holds the values in place of Result when the modifiers so far yielded several.
+	Candidates [][]string `json:"candidates,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Explain validates the input like Schema.Validate does and returns the trace of all matching steps along with the
+// validation result.
+func Explain(schema Schema, input string, context *ValidationContext) (*Trace, error) {
+	trace := &Trace{}
+	tracedContext := *context
+	tracedContext.Trace = trace
+	err := schema.Validate(input, &tracedContext)
+	return trace, err
+}
+
+// String renders the trace as an indented tree.
+func (t *Trace) String() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("input '%s'\n", t.Input))
+	for _, node := range t.Nodes {
+		node.write(&builder, 1)
+	}
+	if t.Error != "" {
+		builder.WriteString(fmt.Sprintf("failed: %s\n", t.Error))
+	} else {
+		builder.WriteString("matched\n")
+	}
+	return builder.String()
+}
+
+// JSON serialises the trace.
+func (t *Trace) JSON() ([]byte, error) {
+	return json.MarshalIndent(t, "", "  ")
+}
+
+func (n *TraceNode) write(builder *strings.Builder, depth int) {
+	builder.WriteString(strings.Repeat("  ", depth))
+	builder.WriteString(n.String())
+	builder.WriteString("\n")
+	for _, child := range n.Children {
+		child.write(builder, depth+1)
+	}
+}
+
+func (n *TraceNode) String() string {
+	switch n.Event {
+	case TraceConsumed:
+		description := fmt.Sprintf("%s consumed '%s' at segment %d", n.Constraint, strings.Join(n.Segments, "/"), n.Index)
+		if n.Member != "" {
+			description += fmt.Sprintf(" with member '%s'", n.Member)
+		}
+		if n.Backtracked {
+			description += ", backtracked"
+		}
+		return description
+	case TraceRejected:
+		if n.Constraint == "" {
+			return fmt.Sprintf("rejected at segment %d: %s", n.Index, n.Error)
+		}
+		return fmt.Sprintf("%s rejected at segment %d: %s", n.Constraint, n.Index, n.Error)
+	case TracePieces:
+		return fmt.Sprintf("%s splitting '%s' at segment %d", n.Constraint, strings.Join(n.Segments, "/"), n.Index)
+	case TraceMember:
+		description := fmt.Sprintf("%s trying member '%s' at segment %d", n.Constraint, n.Member, n.Index)
+		if n.Error != "" {
+			description += fmt.Sprintf(", rejected: %s", n.Error)
+		}
+		return description
+	case TraceVariable:
+		kind, join := "variable", "/"
+		if n.Variable.Set {
+			kind, join = "variable set", ", "
+		}
+		description := fmt.Sprintf("%s '%s' is '%s'", kind, n.Variable.Name, n.Variable.Value)
+		for _, modifier := range n.Variable.Modifiers {
+			if modifier.Error != "" {
+				description += fmt.Sprintf(", %s failed: %s", modifier.Name, modifier.Error)
+				continue
+			}
+			values := []string{strings.Join(modifier.Result, join)}
+			if modifier.Candidates != nil {
+				values = values[:0]
+				for _, candidate := range modifier.Candidates {
+					values = append(values, strings.Join(candidate, join))
+				}
+			}
+			description += fmt.Sprintf(", %s(%s) gives '%s'", modifier.Name, strings.Join(modifier.Args, ", "), strings.Join(values, "' or '"))
+		}
+		return description
+	}
+	return string(n.Event)
+}
+
+// addTraceNode appends a node to the current scope. It returns nil when tracing is disabled or the scope is muted.
+func (s *matchState) addTraceNode(node *TraceNode) *TraceNode {
+	if s == nil || s.trace == nil {
+		return nil
+	}
+	if len(s.trace.scopes) == 0 {
+		s.trace.Nodes = append(s.trace.Nodes, node)
+		return node
+	}
+	scope := s.trace.scopes[len(s.trace.scopes)-1]
+	if scope == nil {
+		return nil
+	}
+	scope.Children = append(scope.Children, node)
+	return node
+}
+
+func (s *matchState) traceMember(constraint Constraint, path []string, member string) *TraceNode {
+	if s == nil || s.trace == nil {
+		return nil
+	}
+	return s.addTraceNode(&TraceNode{
+		Event:      TraceMember,
+		Constraint: constraint.String(),
+		Index:      len(s.segments) - len(path),
+		Member:     member,
+	})
+}
+
+// tracePieces adds the node grouping the steps of matching the pieces of a composite segment to the text of the
+// first segment of the path. It returns nil when tracing is disabled or the scope is muted.
+func (s *matchState) tracePieces(constraint Constraint, path []string) *TraceNode {
+	if s == nil || s.trace == nil {
+		return nil
+	}
+	return s.addTraceNode(&TraceNode{
+		Event:      TracePieces,
+		Constraint: constraint.String(),
+		Index:      len(s.segments) - len(path),
+		Segments:   path[:1],
+	})
+}
+
+// moveToSegment sets the index of the nodes below the node to its own. The pieces of a composite segment only see
+// its text, so the index of their events is not the one of the segment.
+func (n *TraceNode) moveToSegment() {
+	if n == nil {
+		return
+	}
+	for _, child := range n.Children {
+		child.Index = n.Index
+		child.moveToSegment()
+	}
+}
+
+func (s *matchState) traceMemberResult(member *TraceNode, err error) {
+	if member != nil && err != nil {
+		member.Error = traceErrorMessage(err)
+	}
+}
+
+// newTracedVariable starts recording the modifiers applied to a variable, it returns nil when tracing is disabled.
+func (s *matchState) newTracedVariable(name string, value string) *TracedVariable {
+	if s == nil || s.trace == nil {
+		return nil
+	}
+	return &TracedVariable{Name: name, Value: value}
+}
+
+func (v *TracedVariable) addModifier(modifier VariableModifier, candidates [][]string, err error) {
+	if v == nil {
+		return
+	}
+	args := make([]string, 0, len(modifier.Args))
+	for _, arg := range modifier.Args {
+		args = append(args, arg.String())
+	}
+	traced := TracedModifier{Name: modifier.FuncName, Args: args}
+	if err != nil {
+		traced.Error = err.Error()
+	} else if len(candidates) == 1 {
+		// Modifiers may modify the slice in place, so the trace keeps its own copy
+		traced.Result = slices.Clone(candidates[0])
+	} else {
+		for _, candidate := range candidates {
+			traced.Candidates = append(traced.Candidates, slices.Clone(candidate))
+		}
+	}
+	v.Modifiers = append(v.Modifiers, traced)
+}
+
+func (s *matchState) traceVariable(path []string, variable *TracedVariable) {
+	if variable == nil {
+		return
+	}
+	s.addTraceNode(&TraceNode{
+		Event:    TraceVariable,
+		Index:    len(s.segments) - len(path),
+		Variable: variable,
+	})
+}
+
+func (s *matchState) traceConsumed(constraint Constraint, path []string, rest []string, member string) *TraceNode {
+	if s.trace == nil {
+		return nil
+	}
+	return s.addTraceNode(&TraceNode{
+		Event:      TraceConsumed,
+		Constraint: constraint.String(),
+		Index:      len(s.segments) - len(path),
+		Segments:   path[:len(path)-len(rest)],
+		Member:     member,
+	})
+}
+
+func (s *matchState) traceRejected(err *ValidationError) {
+	if s.trace == nil {
+		return
+	}
+	node := &TraceNode{
+		Event: TraceRejected,
+		Index: len(s.segments) - err.remaining,
+		Error: traceErrorMessage(err),
+	}
+	if err.Constraint != nil {
+		node.Constraint = err.Constraint.String()
+	}
+	s.addTraceNode(node)
+}
+
+func (s *matchState) pushTraceScope(scope *TraceNode) {
+	if s != nil && s.trace != nil {
+		s.trace.scopes = append(s.trace.scopes, scope)
+	}
+}
+
+func (s *matchState) popTraceScope() {
+	if s != nil && s.trace != nil {
+		s.trace.scopes = s.trace.scopes[:len(s.trace.scopes)-1]
+	}
+}
+
+// traceErrorMessage returns the message of a failure without the input and position, which the trace already shows.
+func traceErrorMessage(err error) string {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.cause().Error()
+	}
+	return err.Error()
+}
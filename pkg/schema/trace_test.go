@@ -0,0 +1,134 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	store := &mapVariableStore{
+		variables: map[string]string{
+			"gitlab_path": "group1/helm-project1",
+		},
+		sets: map[string][]string{
+			"technologies": {"mssql", "wso/+{0,1}"},
+		},
+	}
+	compiled, err := CreateSchema(`$gitlab_path.strip_last_prefix("helm-")/$[technologies]/admin`)
+	if err != nil {
+		t.Fatalf("Cannot create schema: %v", err)
+	}
+
+	t.Run("Tree", func(t *testing.T) {
+		trace, err := Explain(compiled, "group1/project1/wso/x/admin", &ValidationContext{VariableStore: store})
+		if err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+		expected := `input 'group1/project1/wso/x/admin'
+  variable 'gitlab_path' is 'group1/helm-project1', strip_last_prefix(helm-) gives 'group1/project1'
+  VariableConstraint(gitlab_path) consumed 'group1/project1' at segment 0
+  VariableSetConstraint(technologies) trying member 'mssql' at segment 2, rejected: expected 'mssql', got 'wso'
+    LiteralConstraint(mssql) rejected at segment 2: expected 'mssql', got 'wso'
+  VariableSetConstraint(technologies) trying member 'wso/+{0,1}' at segment 2
+    LiteralConstraint(wso) consumed 'wso' at segment 2
+    WildcardSingleConstraint consumed 'x' at segment 3
+  VariableSetConstraint(technologies) consumed 'wso/x' at segment 2 with member 'wso/+{0,1}'
+  LiteralConstraint(admin) consumed 'admin' at segment 4
+matched
+`
+		if trace.String() != expected {
+			t.Fatalf("Expected trace:\n%s\ngot:\n%s", expected, trace.String())
+		}
+	})
+
+	t.Run("Backtracking", func(t *testing.T) {
+		trace, err := Explain(compiled, "group1/project1/wso/admin", &ValidationContext{VariableStore: store})
+		if err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+		member := trace.Nodes[3]
+		if member.Event != TraceMember || member.Member != "wso/+{0,1}" {
+			t.Fatalf("Expected the second member attempt, got %s", member.String())
+		}
+		// The wildcard first takes "admin", which leaves nothing for the literal, and then backtracks to nothing
+		if len(member.Children) != 3 || !member.Children[1].Backtracked || member.Children[2].Backtracked {
+			t.Fatalf("Expected a backtracked and a successful wildcard attempt, got:\n%s", trace.String())
+		}
+	})
+
+	t.Run("Candidates", func(t *testing.T) {
+		compiled, err := CreateSchema(`$gitlab_path.maybe_strip_last_prefix("helm-").upper(0)/+`)
+		if err != nil {
+			t.Fatalf("Cannot create schema: %v", err)
+		}
+		trace, err := Explain(compiled, "GROUP1/helm-project1/admin", &ValidationContext{VariableStore: store})
+		if err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+		expected := "variable 'gitlab_path' is 'group1/helm-project1', maybe_strip_last_prefix(helm-) gives 'group1/project1' or " +
+			"'group1/helm-project1', upper(0) gives 'GROUP1/project1' or 'GROUP1/helm-project1'"
+		if description := trace.Nodes[0].String(); description != expected {
+			t.Fatalf("Expected %s, got %s", expected, description)
+		}
+	})
+
+	t.Run("SetModifiers", func(t *testing.T) {
+		compiled, err := CreateSchema(`$[technologies].upper().without("MSSQL")/+`)
+		if err != nil {
+			t.Fatalf("Cannot create schema: %v", err)
+		}
+		trace, err := Explain(compiled, "WSO/admin", &ValidationContext{VariableStore: store})
+		if err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+		expected := "variable set 'technologies' is 'mssql, wso/+{0,1}', upper() gives 'MSSQL, WSO/+{0,1}', without(MSSQL) gives 'WSO/+{0,1}'"
+		if description := trace.Nodes[0].String(); description != expected {
+			t.Fatalf("Expected %s, got %s", expected, description)
+		}
+	})
+
+	t.Run("Composite", func(t *testing.T) {
+		compiled, err := CreateSchema(`+/$[technologies]_admin`)
+		if err != nil {
+			t.Fatalf("Cannot create schema: %v", err)
+		}
+		trace, err := Explain(compiled, "x/mssql_admin", &ValidationContext{VariableStore: store})
+		if err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+		pieces := trace.Nodes[1]
+		if pieces.Event != TracePieces || pieces.Index != 1 || len(pieces.Children) < 2 {
+			t.Fatalf("Expected the pieces of the second segment to be traced, got:\n%s", trace.String())
+		}
+		// The set first tries the longest text, and gives characters back until the literal matches the rest
+		last := pieces.Children[len(pieces.Children)-1]
+		if last.String() != "LiteralConstraint(_admin) consumed '_admin' at segment 1" {
+			t.Fatalf("Expected the literal piece to consume the end of the segment last, got:\n%s", trace.String())
+		}
+		if first := pieces.Children[0]; first.Member != "mssql" || first.Error != "expected 'mssql', got 'mssql_admin'" {
+			t.Fatalf("Expected the set to try the whole segment first, got:\n%s", trace.String())
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		trace, err := Explain(compiled, "group1/project1/kafka/admin", &ValidationContext{VariableStore: store})
+		if err == nil {
+			t.Fatalf("Validation succeeded when it was expected to fail")
+		}
+		serialised, err := trace.JSON()
+		if err != nil {
+			t.Fatalf("Cannot serialise trace: %v", err)
+		}
+		var decoded Trace
+		if err := json.Unmarshal(serialised, &decoded); err != nil {
+			t.Fatalf("Cannot decode trace: %v", err)
+		}
+		if decoded.Error == "" || len(decoded.Nodes) != len(trace.Nodes) {
+			t.Fatalf("Decoded trace differs from the original:\n%s", serialised)
+		}
+		last := decoded.Nodes[len(decoded.Nodes)-1]
+		if last.Event != TraceRejected || last.Constraint != "VariableSetConstraint(technologies)" || last.Index != 2 {
+			t.Fatalf("Expected the variable set to reject the input last, got %s", last.String())
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UUIDConstraint matches a single segment holding a UUID in its canonical form, e.g.
+// 123e4567-e89b-12d3-a456-426614174000, written with <uuid>.
+type UUIDConstraint struct{}
+
+// IntConstraint matches a single segment holding a decimal integer, optionally within a range, e.g. <int:1..99>.
+// Either bound of the range may be left out, e.g. <int:1..>, and a single number is the only one allowed.
+type IntConstraint struct {
+	Min *int
+	Max *int
+}
+
+// SemverConstraint matches a single segment holding a semantic version, e.g. 1.4.0-rc.1, written with <semver>.
+// A prefix such as 'v' is written as a composite segment, e.g. v<semver>.
+type SemverConstraint struct{}
+
+// DateConstraint matches a single segment holding a date in the layout of the time package, e.g. <date:2006-01>.
+// The date must be written exactly as the layout formats it.
+type DateConstraint struct {
+	Layout string
+}
+
+// DNSLabelConstraint matches a single segment which is a valid DNS label according to RFC 1123, in lower case,
+// e.g. helm-project1, written with <dns_label>.
+type DNSLabelConstraint struct{}
+
+var (
+	uuidPattern     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	semverPattern   = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+	dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// defaultDateLayout is used by <date> without a layout.
+const defaultDateLayout = "2006-01-02"
+
+// compileType compiles a typed segment from its type and arguments, e.g. int:1..99.
+func compileType(spec string) (Constraint, error) {
+	name, args, hasArgs := strings.Cut(spec, ":")
+	var constraint Constraint
+	switch name {
+	case "int":
+		if hasArgs {
+			return compileIntRange(args)
+		}
+		return &IntConstraint{}, nil
+	case "date":
+		if !hasArgs {
+			return &DateConstraint{Layout: defaultDateLayout}, nil
+		}
+		if args == "" {
+			return nil, fmt.Errorf("type 'date' needs a layout, e.g. <date:2006-01>")
+		}
+		return &DateConstraint{Layout: args}, nil
+	case "uuid":
+		constraint = &UUIDConstraint{}
+	case "semver":
+		constraint = &SemverConstraint{}
+	case "dns_label":
+		constraint = &DNSLabelConstraint{}
+	default:
+		return nil, fmt.Errorf("unknown type '%s', expected one of uuid, int, semver, date or dns_label", name)
+	}
+	if hasArgs {
+		return nil, fmt.Errorf("type '%s' takes no arguments", name)
+	}
+	return constraint, nil
+}
+
+func compileIntRange(args string) (*IntConstraint, error) {
+	minimum, maximum, err := parseRange(args)
+	if err != nil {
+		return nil, fmt.Errorf("type 'int': %w", err)
+	}
+	return &IntConstraint{Min: minimum, Max: maximum}, nil
+}
+
+func (c *UUIDConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *UUIDConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	if len(path) <= 0 {
+		return newValidationError(c, path, ReasonTooShort, []string{"<uuid>"}, "empty path")
+	}
+	if !uuidPattern.MatchString(path[0]) {
+		return newValidationError(c, path, ReasonTypeMismatch, []string{"<uuid>"}, "'%s' is not a UUID", path[0])
+	}
+	return context.state.advance(c, path, path[1:], "", next)
+}
+
+func (c *UUIDConstraint) String() string {
+	return "UUIDConstraint()"
+}
+
+func (c *UUIDConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *IntConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *IntConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	if len(path) <= 0 {
+		return newValidationError(c, path, ReasonTooShort, []string{c.source()}, "empty path")
+	}
+	// Atoi accepts a leading '+', which is not how numbers appear in paths
+	value, err := strconv.Atoi(path[0])
+	if err != nil || strings.HasPrefix(path[0], "+") {
+		return newValidationError(c, path, ReasonTypeMismatch, []string{c.source()}, "'%s' is not an integer", path[0])
+	}
+	if c.Min != nil && value < *c.Min {
+		return newValidationError(c, path, ReasonOutOfRange, []string{c.source()}, "%d is less than the minimum %d", value, *c.Min)
+	}
+	if c.Max != nil && value > *c.Max {
+		return newValidationError(c, path, ReasonOutOfRange, []string{c.source()}, "%d is greater than the maximum %d", value, *c.Max)
+	}
+	return context.state.advance(c, path, path[1:], "", next)
+}
+
+// source renders the constraint as it's written in the schema, e.g. <int:1..99>.
+func (c *IntConstraint) source() string {
+	if c.Min == nil && c.Max == nil {
+		return "<int>"
+	}
+	bounds := ".."
+	if c.Min != nil {
+		bounds = strconv.Itoa(*c.Min) + bounds
+	}
+	if c.Max != nil {
+		bounds += strconv.Itoa(*c.Max)
+	}
+	return "<int:" + bounds + ">"
+}
+
+func (c *IntConstraint) String() string {
+	return fmt.Sprintf("IntConstraint(%s)", c.source())
+}
+
+func (c *IntConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *SemverConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *SemverConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	if len(path) <= 0 {
+		return newValidationError(c, path, ReasonTooShort, []string{"<semver>"}, "empty path")
+	}
+	if !semverPattern.MatchString(path[0]) {
+		return newValidationError(c, path, ReasonTypeMismatch, []string{"<semver>"}, "'%s' is not a semantic version", path[0])
+	}
+	return context.state.advance(c, path, path[1:], "", next)
+}
+
+func (c *SemverConstraint) String() string {
+	return "SemverConstraint()"
+}
+
+func (c *SemverConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *DateConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *DateConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	source := "<date:" + c.Layout + ">"
+	if len(path) <= 0 {
+		return newValidationError(c, path, ReasonTooShort, []string{source}, "empty path")
+	}
+	// Parsing alone accepts some variations of the layout, e.g. month names in any case
+	date, err := time.Parse(c.Layout, path[0])
+	if err != nil || date.Format(c.Layout) != path[0] {
+		return newValidationError(c, path, ReasonTypeMismatch, []string{source}, "'%s' is not a date in the layout '%s'", path[0], c.Layout)
+	}
+	return context.state.advance(c, path, path[1:], "", next)
+}
+
+func (c *DateConstraint) String() string {
+	return fmt.Sprintf("DateConstraint(%s)", c.Layout)
+}
+
+func (c *DateConstraint) GetVariableName() string {
+	return ""
+}
+
+func (c *DNSLabelConstraint) Consume(path []string, context *ValidationContext) ([]string, error) {
+	return consumeFirst(c, path, context)
+}
+
+func (c *DNSLabelConstraint) Match(path []string, context *ValidationContext, next Continuation) error {
+	if len(path) <= 0 {
+		return newValidationError(c, path, ReasonTooShort, []string{"<dns_label>"}, "empty path")
+	}
+	if !dnsLabelPattern.MatchString(path[0]) {
+		return newValidationError(c, path, ReasonTypeMismatch, []string{"<dns_label>"}, "'%s' is not a DNS label", path[0])
+	}
+	return context.state.advance(c, path, path[1:], "", next)
+}
+
+func (c *DNSLabelConstraint) String() string {
+	return "DNSLabelConstraint()"
+}
+
+func (c *DNSLabelConstraint) GetVariableName() string {
+	return ""
+}
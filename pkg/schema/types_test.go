@@ -0,0 +1,76 @@
+package schema
+
+import "testing"
+
+func TestUUIDConstraint(t *testing.T) {
+	cases := []constraintTestCase{
+		{constraint: &UUIDConstraint{}, path: []string{"123e4567-e89b-12d3-a456-426614174000", "x"}, expectedRest: []string{"x"}},
+		{constraint: &UUIDConstraint{}, path: []string{"123E4567-E89B-12D3-A456-426614174000"}, expectedRest: []string{}},
+		{constraint: &UUIDConstraint{}, path: []string{"123e4567e89b12d3a456426614174000"}, shouldFail: true},
+		{constraint: &UUIDConstraint{}, path: []string{"123e4567-e89b-12d3-a456-42661417400g"}, shouldFail: true},
+		{constraint: &UUIDConstraint{}, path: []string{}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(t)
+	}
+}
+
+func TestIntConstraint(t *testing.T) {
+	bound := func(value int) *int {
+		return &value
+	}
+	cases := []constraintTestCase{
+		{constraint: &IntConstraint{}, path: []string{"-12"}, expectedRest: []string{}},
+		{constraint: &IntConstraint{}, path: []string{"+12"}, shouldFail: true},
+		{constraint: &IntConstraint{}, path: []string{"1.5"}, shouldFail: true},
+		{constraint: &IntConstraint{Min: bound(1), Max: bound(99)}, path: []string{"1"}, expectedRest: []string{}},
+		{constraint: &IntConstraint{Min: bound(1), Max: bound(99)}, path: []string{"99"}, expectedRest: []string{}},
+		{constraint: &IntConstraint{Min: bound(1), Max: bound(99)}, path: []string{"100"}, shouldFail: true},
+		{constraint: &IntConstraint{Min: bound(1)}, path: []string{"100000"}, expectedRest: []string{}},
+		{constraint: &IntConstraint{Max: bound(9)}, path: []string{"10"}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(t)
+	}
+}
+
+func TestSemverConstraint(t *testing.T) {
+	cases := []constraintTestCase{
+		{constraint: &SemverConstraint{}, path: []string{"1.2.3"}, expectedRest: []string{}},
+		{constraint: &SemverConstraint{}, path: []string{"1.0.0-rc.1+build.5"}, expectedRest: []string{}},
+		{constraint: &SemverConstraint{}, path: []string{"v1.2.3"}, shouldFail: true},
+		{constraint: &SemverConstraint{}, path: []string{"1.2"}, shouldFail: true},
+		{constraint: &SemverConstraint{}, path: []string{"01.2.3"}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(t)
+	}
+}
+
+func TestDateConstraint(t *testing.T) {
+	cases := []constraintTestCase{
+		{constraint: &DateConstraint{Layout: "2006-01"}, path: []string{"2024-03"}, expectedRest: []string{}},
+		{constraint: &DateConstraint{Layout: "2006-01"}, path: []string{"2024-3"}, shouldFail: true},
+		{constraint: &DateConstraint{Layout: "2006-01-02"}, path: []string{"2024-04-31"}, shouldFail: true},
+		{constraint: &DateConstraint{Layout: "2006-Jan"}, path: []string{"2024-Mar"}, expectedRest: []string{}},
+		// Month names are parsed in any case, but only match as the layout writes them
+		{constraint: &DateConstraint{Layout: "2006-Jan"}, path: []string{"2024-mar"}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(t)
+	}
+}
+
+func TestDNSLabelConstraint(t *testing.T) {
+	cases := []constraintTestCase{
+		{constraint: &DNSLabelConstraint{}, path: []string{"helm-project1"}, expectedRest: []string{}},
+		{constraint: &DNSLabelConstraint{}, path: []string{"1abc"}, expectedRest: []string{}},
+		{constraint: &DNSLabelConstraint{}, path: []string{"-abc"}, shouldFail: true},
+		{constraint: &DNSLabelConstraint{}, path: []string{"abc-"}, shouldFail: true},
+		{constraint: &DNSLabelConstraint{}, path: []string{"a_b"}, shouldFail: true},
+		{constraint: &DNSLabelConstraint{}, path: []string{"abcdefghij-abcdefghij-abcdefghij-abcdefghij-abcdefghij-abcdefghij"}, shouldFail: true},
+	}
+	for _, testCase := range cases {
+		testCase.test(t)
+	}
+}